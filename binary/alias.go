@@ -0,0 +1,268 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// User aliases name a version expression, like nvm's: `work` -> `20`,
+// `legacy` -> `work`. Each alias is a file in ~/.nvu/aliases holding its
+// target, except `default`, which is the global default file itself. Aliases
+// can be used anywhere a version is expected - version files, NVU_VERSION,
+// `nvu <alias> <command>` - and may point at other aliases.
+
+// maxAliasDepth bounds alias chains so a cycle can't go unnoticed
+const maxAliasDepth = 32
+
+// reservedAliasNames already mean something as a version or as a subcommand
+var reservedAliasNames = []string{"system", "engines", "lts", "off", "install", "uninstall", "list", "local", "which", "setup", "teardown", "alias", "unalias"}
+
+// getAliasPath returns the file that stores an alias
+func getAliasPath(name string) (string, error) {
+	nvuHome, err := getNvuHome()
+	if err != nil {
+		return "", err
+	}
+	if name == "default" {
+		return filepath.Join(nvuHome, "default"), nil
+	}
+	return filepath.Join(nvuHome, "aliases", name), nil
+}
+
+// isAliasName reports whether name can be an alias: it starts with a letter,
+// contains only letters, digits, '-', '_' and '.', and can't be read as a
+// version or as another keyword
+func isAliasName(name string) bool {
+	if name == "" || !(name[0] >= 'a' && name[0] <= 'z' || name[0] >= 'A' && name[0] <= 'Z') {
+		return false
+	}
+	for _, char := range name {
+		if !(char >= 'a' && char <= 'z' || char >= 'A' && char <= 'Z' || char >= '0' && char <= '9' || char == '-' || char == '_' || char == '.') {
+			return false
+		}
+	}
+	for _, reserved := range reservedAliasNames {
+		if strings.EqualFold(name, reserved) {
+			return false
+		}
+	}
+	return !isLatestAlias(name) && !isLTSAlias(name) && !isVersionSyntax(name)
+}
+
+// readAlias returns the target of an alias, or "" if it isn't defined
+func readAlias(name string) (string, error) {
+	if !isAliasName(name) {
+		return "", nil
+	}
+	aliasPath, err := getAliasPath(name)
+	if err != nil {
+		return "", err
+	}
+	content, err := readSourceFile(aliasPath)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read alias %s: %w", name, err)
+	}
+	target, _ := parseVersionFile(string(content))
+	return target, nil
+}
+
+// isKnownAlias reports whether name is a defined alias
+func isKnownAlias(name string) bool {
+	target, err := readAlias(name)
+	return err == nil && target != ""
+}
+
+// expandAlias follows an alias chain to the version expression it names.
+// Anything that isn't a defined alias is returned unchanged.
+func expandAlias(version string) (string, error) {
+	chain := []string{version}
+	for {
+		target, err := readAlias(version)
+		if err != nil {
+			return "", err
+		}
+		if target == "" {
+			return version, nil
+		}
+		for _, seen := range chain {
+			if seen == target {
+				return "", fmt.Errorf("alias cycle: %s", strings.Join(append(chain, target), " -> "))
+			}
+		}
+		if len(chain) > maxAliasDepth {
+			return "", fmt.Errorf("alias chain too long: %s", strings.Join(chain, " -> "))
+		}
+		chain = append(chain, target)
+		version = target
+	}
+}
+
+// expandVersionAlias expands a version read from source. A broken alias is
+// reported against that source, like any other unusable version.
+func expandVersionAlias(version string, source versionSource) (string, versionSource, error) {
+	expanded, err := expandAlias(version)
+	if err != nil {
+		return "", source, &sourceError{source: source, err: err}
+	}
+	return expanded, source, nil
+}
+
+// listAliases returns the names of all defined aliases, sorted, with
+// "default" first when it is set
+func listAliases() ([]string, error) {
+	nvuHome, err := getNvuHome()
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	if isKnownAlias("default") {
+		names = append(names, "default")
+	}
+	entries, err := os.ReadDir(filepath.Join(nvuHome, "aliases"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	var others []string
+	for _, entry := range entries {
+		if !entry.IsDir() && isAliasName(entry.Name()) && entry.Name() != "default" {
+			others = append(others, entry.Name())
+		}
+	}
+	sort.Strings(others)
+	return append(names, others...), nil
+}
+
+// describeAlias formats an alias with its target and what it resolves to,
+// e.g. "legacy -> work -> 20 (-> v20.19.6)"
+func describeAlias(name string) string {
+	expanded, err := expandAlias(name)
+	if err != nil {
+		return name + " (" + err.Error() + ")"
+	}
+
+	chain := []string{name}
+	for version := name; version != expanded && version != ""; {
+		version, _ = readAlias(version)
+		chain = append(chain, version)
+	}
+	description := strings.Join(chain, " -> ")
+	// for a preference list, describe the entry that would be used
+	for _, item := range strings.Split(expanded, ",") {
+		if item, ok := findAvailableListItem(strings.TrimSpace(item)); ok || !isVersionList(expanded) {
+			expanded = item
+			break
+		}
+	}
+	if expanded == "system" {
+		return description
+	}
+	nvuHome, err := getNvuHome()
+	if err != nil {
+		return description
+	}
+	resolved, err := resolveInstalledVersion(filepath.Join(nvuHome, "installed"), expanded)
+	if err != nil {
+		return description + " (not installed)"
+	}
+	return description + " (-> " + resolved + ")"
+}
+
+// runAlias handles 'nvu alias [name [version]]': with no arguments it lists
+// every alias, with a name it shows that alias, and with a version it sets it
+func runAlias(args []string) {
+	switch len(args) {
+	case 0:
+		names, err := listAliases()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "nvu error: failed to list aliases: %s\n", err)
+			os.Exit(1)
+		}
+		for _, name := range names {
+			fmt.Println(describeAlias(name))
+		}
+	case 1:
+		if !isKnownAlias(args[0]) {
+			fmt.Fprintf(os.Stderr, "nvu error: alias not found: %s\n", args[0])
+			os.Exit(1)
+		}
+		fmt.Println(describeAlias(args[0]))
+	case 2:
+		if err := setAlias(args[0], strings.TrimSpace(args[1])); err != nil {
+			fmt.Fprintf(os.Stderr, "nvu error: %s\n", err)
+			os.Exit(1)
+		}
+		fmt.Println(describeAlias(args[0]))
+	default:
+		fmt.Fprintf(os.Stderr, "Usage: nvu alias [name [version]]\n")
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+// runUnalias handles 'nvu unalias <name>'
+func runUnalias(args []string) {
+	if len(args) != 1 {
+		fmt.Fprintf(os.Stderr, "Usage: nvu unalias <name>\n")
+		os.Exit(1)
+	}
+	if !isKnownAlias(args[0]) {
+		fmt.Fprintf(os.Stderr, "nvu error: alias not found: %s\n", args[0])
+		os.Exit(1)
+	}
+	aliasPath, err := getAliasPath(args[0])
+	if err == nil {
+		err = os.Remove(aliasPath)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "nvu error: failed to remove alias %s: %s\n", args[0], err)
+		os.Exit(1)
+	}
+	fmt.Printf("Deleted alias %s\n", args[0])
+	os.Exit(0)
+}
+
+// setAlias points an alias at a version expression, refusing names that
+// already mean something and targets that would close a cycle
+func setAlias(name string, target string) error {
+	if !isAliasName(name) {
+		return fmt.Errorf("invalid alias name %q (it must start with a letter and not be a version or keyword)", name)
+	}
+	if err := validateVersionExpression(target); err != nil {
+		return err
+	}
+	// a chain from target leading back to name would close a loop
+	chain := []string{name}
+	for version := target; ; {
+		chain = append(chain, version)
+		if version == name {
+			return fmt.Errorf("alias cycle: %s", strings.Join(chain, " -> "))
+		}
+		next, err := readAlias(version)
+		if err != nil || next == "" || len(chain) > maxAliasDepth {
+			break
+		}
+		version = next
+	}
+	if _, err := expandAlias(target); err != nil {
+		return err
+	}
+
+	aliasPath, err := getAliasPath(name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(aliasPath), 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", filepath.Dir(aliasPath), err)
+	}
+	if err := os.WriteFile(aliasPath, []byte(target+"\n"), 0644); err != nil {
+		return fmt.Errorf("failed to write alias %s: %w", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Auto-install: when a core binary's version isn't installed and autoInstall
+// is on, the shim runs `nvu install <version>` itself and then carries on
+// with the original command. On a terminal it asks first; with CI set it
+// proceeds without asking; anywhere else it leaves the usual error alone.
+// Parallel shims serialize on ~/.nvu/install.lock, and a shim that waited
+// finds the version installed and skips the download. The lock is held by
+// the operating system, so an install that is killed releases it at once.
+// A shim started below the install (by the CLI, or by npm run through
+// `#!/usr/bin/env node`) that needs the lock too fails instead of waiting
+// on its own ancestor.
+
+// installLockHolderEnv carries the pid of the shim holding the install lock
+// to everything it starts
+const installLockHolderEnv = "NVU_INSTALL_LOCK_HOLDER"
+
+// installMissingVersions lets a version source whose failure would stop
+// resolution, such as devEngines.runtime, auto-install its version first. It
+// is set for the core binaries, which auto-install anyway.
+var installMissingVersions bool
+
+// autoInstall installs a missing version when auto-install is enabled, and
+// reports whether it is now installed
+func autoInstall(version string, source versionSource) bool {
+	if !getConfig().AutoInstall || version == "system" {
+		return false
+	}
+
+	nvuHome, err := getNvuHome()
+	if err != nil {
+		return false
+	}
+	versionsDir := filepath.Join(nvuHome, "installed")
+	if _, err := resolveInstalledVersion(versionsDir, version); err == nil {
+		return false // installed, but the binary itself is missing
+	}
+
+	if !confirmAutoInstall(version, source) {
+		return false
+	}
+
+	unlock, err := acquireInstallLock(nvuHome)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "nvu error: %s\n", err)
+		return false
+	}
+	defer unlock()
+
+	// another shim may have installed it while this one waited for the lock
+	if _, err := findInstalledVersion(versionsDir, version); err == nil {
+		return true
+	}
+
+	if err := runCliInstall(version); err != nil {
+		fmt.Fprintf(os.Stderr, "nvu error: failed to install Node %s: %s\n", version, err)
+		return false
+	}
+	return true
+}
+
+// confirmAutoInstall asks before installing on a terminal, proceeds when CI
+// is set, and declines otherwise
+func confirmAutoInstall(version string, source versionSource) bool {
+	if os.Getenv("CI") != "" {
+		fmt.Fprintf(os.Stderr, "nvu: installing Node %s (from %s)\n", version, source)
+		return true
+	}
+	if !isTerminal(os.Stdin) || !isTerminal(os.Stderr) {
+		fmt.Fprintf(os.Stderr, "nvu: not installing Node %s automatically without a terminal to confirm on (set CI=1 to skip the prompt)\n", version)
+		return false
+	}
+
+	fmt.Fprintf(os.Stderr, "Node %s (from %s) is not installed. Install it now? [Y/n] ", version, source)
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		fmt.Fprintln(os.Stderr)
+		return false
+	}
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "", "y", "yes":
+		return true
+	}
+	return false
+}
+
+// isTerminal reports whether f is a character device such as a TTY
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// acquireInstallLock takes ~/.nvu/install.lock, waiting while another
+// install holds it, unless the holder is an ancestor of this process. The
+// returned function releases it.
+func acquireInstallLock(nvuHome string) (func(), error) {
+	if err := os.MkdirAll(nvuHome, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create %s: %w", nvuHome, err)
+	}
+	lockPath := filepath.Join(nvuHome, "install.lock")
+
+	waiting := false
+	for {
+		release, ok, err := tryLockFile(lockPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to lock %s: %w", lockPath, err)
+		}
+		if ok {
+			os.Setenv(installLockHolderEnv, strconv.Itoa(os.Getpid()))
+			return func() {
+				os.Unsetenv(installLockHolderEnv)
+				release()
+			}, nil
+		}
+		if holder := readLockHolder(lockPath); holder != "" && holder == os.Getenv(installLockHolderEnv) {
+			return nil, fmt.Errorf("%s is held by process %s, which started this one, so it would never be released", lockPath, holder)
+		}
+		if !waiting {
+			waiting = true
+			fmt.Fprintf(os.Stderr, "nvu: waiting for another install to finish (%s)\n", lockPath)
+		}
+		time.Sleep(250 * time.Millisecond)
+	}
+}
+
+// readLockHolder returns the pid written to a lock file by its holder, or ""
+func readLockHolder(lockPath string) string {
+	content, err := os.ReadFile(lockPath)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(content))
+}
+
+// runCliInstall runs `nvu install <version>` with the nvu CLI of the global
+// default, or of the system node if the default can't run it
+func runCliInstall(version string) error {
+	var nodePath, nvuScript string
+	var err error
+	if defaultVersion, _, defaultErr := readDefaultVersion(); defaultErr == nil {
+		if defaultVersion, defaultErr = expandAlias(defaultVersion); defaultErr == nil {
+			nodePath, nvuScript, err = findNvuCli(selectFromList(defaultVersion))
+		}
+	}
+	if nodePath == "" {
+		nodePath, nvuScript, err = findNvuCli("system")
+	}
+	if err != nil {
+		return err
+	}
+
+	checkNotShim(nodePath)
+	cmd := exec.Command(nodePath, nvuScript, "install", version)
+	cmd.Stdout = os.Stderr // keep the command's own stdout clean
+	cmd.Stderr = os.Stderr
+	cmd.Env = buildEnv(map[string]string{"PATH": getPathWithoutNvuBinWithPrepend(filepath.Dir(nodePath)), "NVU_AUTO_INSTALL": "0"})
+	code, err := runSupervised(cmd)
+	if err != nil {
+		return err
+	}
+	if code != 0 {
+		return fmt.Errorf("nvu install exited with code %d", code)
+	}
+	return nil
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+)
+
+// The resolution cache remembers, per working directory, which version
+// expression applies, where it came from and which installed directory it
+// resolved to. Each entry lists every file and directory the resolution
+// depended on with its mtime, size and inode; a warm invocation only stats
+// those instead of walking the parents and scanning installed/.
+//
+// Entries live in ~/.nvu/cache/resolve/<hash>.json, one file per key, and are
+// replaced atomically (write to a temp file, then rename), so concurrent
+// shims never see a partial entry and the last writer wins.
+
+// resolutionCacheVersion is bumped whenever the entry format changes
+const resolutionCacheVersion = 1
+
+// fileStamp identifies one state of a file or directory
+type fileStamp struct {
+	Path    string `json:"path"`
+	Exists  bool   `json:"exists"`
+	ModTime int64  `json:"mtime,omitempty"`
+	Size    int64  `json:"size,omitempty"`
+	Inode   uint64 `json:"ino,omitempty"`
+}
+
+// resolutionCacheEntry is one cached resolution
+type resolutionCacheEntry struct {
+	Format    int         `json:"format"`
+	Key       string      `json:"key"`
+	Version   string      `json:"version"`
+	Path      string      `json:"path,omitempty"`
+	Line      int         `json:"line,omitempty"`
+	Field     string      `json:"field,omitempty"`
+	Installed string      `json:"installed,omitempty"`
+	Deps      []fileStamp `json:"deps"`
+}
+
+// resolutionDeps collects what the current resolution depended on; nil when
+// nothing is being recorded
+var resolutionDeps map[string]fileStamp
+
+// resolutionUncacheable is set when the resolution had side effects, such
+// as warnings, that a cached result would skip
+var resolutionUncacheable bool
+
+// markUncacheable keeps the current resolution out of the cache: it printed a
+// note or warning that every run has to repeat, or depended on something no
+// stamp covers, such as whether PATH has a system node
+func markUncacheable() {
+	resolutionUncacheable = true
+}
+
+// pendingResolution is the walk result waiting for its installed directory
+// before it is written to the cache
+var pendingResolution *resolutionCacheEntry
+
+// installedVersionMemo maps version expressions to installed directory names
+// resolved in this process, including ones restored from the cache
+var installedVersionMemo = make(map[string]string)
+
+// isResolutionCacheEnabled reports whether the cache is in use
+func isResolutionCacheEnabled() bool {
+	return !getConfig().NoResolveCache
+}
+
+// statFile returns the current stamp of a path
+func statFile(path string) fileStamp {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileStamp{Path: path}
+	}
+	return fileStamp{
+		Path:    path,
+		Exists:  true,
+		ModTime: info.ModTime().UnixNano(),
+		Size:    info.Size(),
+		Inode:   fileInode(info),
+	}
+}
+
+// recordDep notes that the resolution depended on a file or directory,
+// including on its absence
+func recordDep(path string) {
+	if resolutionDeps == nil {
+		return
+	}
+	if _, ok := resolutionDeps[path]; !ok {
+		resolutionDeps[path] = statFile(path)
+	}
+}
+
+// readSourceFile reads a file that affects resolution, recording it as a
+// dependency. A missing file is covered by its directory's mtime instead.
+func readSourceFile(path string) ([]byte, error) {
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		recordDep(filepath.Dir(path))
+	} else {
+		recordDep(path)
+	}
+	return content, err
+}
+
+// getResolutionCachePath returns the entry file for a key
+func getResolutionCachePath(key string) (string, error) {
+	nvuHome, err := getNvuHome()
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(nvuHome, "cache", "resolve", hex.EncodeToString(sum[:])+".json"), nil
+}
+
+// getResolutionCacheKey identifies a resolution: the start directory plus
+// every setting that changes how it is resolved
+func getResolutionCacheKey(cwd string) string {
+	nvuHome, _ := getNvuHome()
+	settings, _ := json.Marshal(getConfig())
+	return cwd + "\x00" + nvuHome + "\x00" + string(settings)
+}
+
+// loadResolutionCache returns the cached resolution for cwd if every file it
+// depended on is unchanged, and starts recording dependencies otherwise
+func loadResolutionCache(cwd string) *resolutionCacheEntry {
+	if !isResolutionCacheEnabled() {
+		return nil
+	}
+	resolutionDeps = make(map[string]fileStamp)
+
+	key := getResolutionCacheKey(cwd)
+	cachePath, err := getResolutionCachePath(key)
+	if err != nil {
+		return nil
+	}
+	content, err := os.ReadFile(cachePath)
+	if err != nil {
+		return nil
+	}
+
+	var entry resolutionCacheEntry
+	if err := json.Unmarshal(content, &entry); err != nil || entry.Format != resolutionCacheVersion || entry.Key != key {
+		return nil
+	}
+	for _, dep := range entry.Deps {
+		if statFile(dep.Path) != dep {
+			return nil
+		}
+	}
+
+	resolutionDeps = nil
+	if entry.Installed != "" {
+		installedVersionMemo[entry.Version] = entry.Installed
+	}
+	return &entry
+}
+
+// source returns where the cached version came from
+func (entry *resolutionCacheEntry) source() versionSource {
+	return versionSource{path: entry.Path, line: entry.Line, field: entry.Field}
+}
+
+// setPendingResolution remembers a fresh walk result so it can be cached
+// once its installed directory is known
+func setPendingResolution(cwd string, version string, source versionSource) {
+	if resolutionDeps == nil || resolutionUncacheable {
+		return
+	}
+	pendingResolution = &resolutionCacheEntry{
+		Format:  resolutionCacheVersion,
+		Key:     getResolutionCacheKey(cwd),
+		Version: version,
+		Path:    source.path,
+		Line:    source.line,
+		Field:   source.field,
+	}
+}
+
+// saveResolutionCache writes the pending resolution, if any. Failures are
+// ignored: the cache only ever saves work.
+func saveResolutionCache() {
+	entry := pendingResolution
+	if entry == nil || resolutionUncacheable {
+		return
+	}
+	pendingResolution = nil
+	entry.Installed = installedVersionMemo[entry.Version]
+	for _, dep := range resolutionDeps {
+		entry.Deps = append(entry.Deps, dep)
+	}
+
+	cachePath, err := getResolutionCachePath(entry.Key)
+	if err != nil {
+		return
+	}
+	content, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(cachePath), 0755); err != nil {
+		return
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(cachePath), ".entry-*")
+	if err != nil {
+		return
+	}
+	_, writeErr := tmp.Write(content)
+	closeErr := tmp.Close()
+	if writeErr != nil || closeErr != nil || os.Rename(tmp.Name(), cachePath) != nil {
+		os.Remove(tmp.Name())
+	}
+}
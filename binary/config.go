@@ -0,0 +1,226 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// config holds user settings from ~/.nvu/config.json. Environment variables
+// take precedence over the file so a single command can override them.
+type config struct {
+	// Select is the policy used when several installed versions match:
+	// "highest" (default), "lowest" or "prefer-lts" (env: NVU_SELECT)
+	Select string `json:"select"`
+
+	// VersionFiles is the ordered list of file names checked in each
+	// directory (env: NVU_VERSION_FILES, comma-separated)
+	VersionFiles []string `json:"versionFiles"`
+
+	// Engines opts in to package.json engines.node, checked "before" or
+	// "after" the version files in each directory; "off" by default
+	// (env: NVU_ENGINES)
+	Engines string `json:"engines"`
+
+	// NodeVersionEnv opts in to NODE_VERSION as an alias of NVU_VERSION. It is
+	// off by default because images like the official node ones set it to the
+	// version they ship (env: NVU_NODE_VERSION_ENV=1)
+	NodeVersionEnv bool `json:"nodeVersionEnv"`
+
+	// CeilingDirectories stop the version file walk before it enters them
+	// (env: NVU_CEILING_DIRECTORIES, a PATH-style list)
+	CeilingDirectories []string `json:"ceilingDirectories"`
+
+	// StopAtGitRoot ends the walk at the root of the enclosing git checkout
+	// (env: NVU_STOP_AT_GIT_ROOT=1)
+	StopAtGitRoot bool `json:"stopAtGitRoot"`
+
+	// IncludeNodeModules reads version files inside node_modules, which are
+	// skipped by default (env: NVU_INCLUDE_NODE_MODULES=1)
+	IncludeNodeModules bool `json:"includeNodeModules"`
+
+	// Cwd is "logical" (default) to start from the directory as the shell
+	// sees it, or "physical" to resolve symlinks first (env: NVU_CWD)
+	Cwd string `json:"cwd"`
+
+	// NoResolveCache turns off the resolution cache in ~/.nvu/cache/resolve
+	// (env: NVU_NO_RESOLVE_CACHE=1)
+	NoResolveCache bool `json:"noResolveCache"`
+
+	// Strict turns every fallback (system binary when nothing is configured,
+	// another version's binary for a missing tool) into an error with its own
+	// exit code (env: NVU_STRICT=1)
+	Strict bool `json:"strict"`
+
+	// WarnFallback prints a warning whenever a fallback happens outside
+	// strict mode (env: NVU_WARN_FALLBACK=1)
+	WarnFallback bool `json:"warnFallback"`
+
+	// AutoInstall lets the shim install a missing version before running a
+	// core binary, after asking on a terminal or straight away when CI is set
+	// (env: NVU_AUTO_INSTALL=1)
+	AutoInstall bool `json:"autoInstall"`
+
+	// ResolveFrom is "cwd" (default) to resolve from the current directory,
+	// or "script" for the node shim to resolve from the directory of the
+	// script it runs (env: NVU_RESOLVE_FROM)
+	ResolveFrom string `json:"resolveFrom"`
+
+	// Fallback is the policy for a configured version that isn't installed:
+	// "fail" (default), "same-minor", "same-major", "nearest-higher" or
+	// "default". A project's .nvurc can set its own (env: NVU_FALLBACK)
+	Fallback string `json:"fallback"`
+
+	// FallbackFromEnv records that Fallback came from NVU_FALLBACK, which
+	// beats a project's .nvurc while config.json doesn't
+	FallbackFromEnv bool `json:"-"`
+
+	// NoPin stops the shim from pinning its version for child processes and
+	// from honouring a pin it inherited (env: NVU_PIN=0)
+	NoPin bool `json:"noPin"`
+
+	// HookTimeout limits how long each pre-exec or post-exec hook may run, as
+	// a duration such as "10s" (default) (env: NVU_HOOK_TIMEOUT)
+	HookTimeout string `json:"hookTimeout"`
+
+	// ProjectHooks runs the hooks a project's .nvurc declares. They are off by
+	// default because they run code from whatever directory you are in
+	// (env: NVU_PROJECT_HOOKS=1)
+	ProjectHooks bool `json:"projectHooks"`
+
+	// MaxShimHops is how many binaries shims may run below one another
+	// before the shim reports a loop, 32 by default (env: NVU_MAX_SHIM_HOPS)
+	MaxShimHops int `json:"maxShimHops"`
+}
+
+// defaultVersionFiles are checked when no list is configured: nvu's own file
+// first, then the ones shared with nvm, with fnm, nodenv and n, with asdf and
+// mise, and finally the Volta pin and npm devEngines.runtime in package.json
+var defaultVersionFiles = []string{".nvurc", ".nvmrc", ".node-version", toolVersionsFile, voltaNodeSource, devEnginesRuntimeSource}
+
+var loadedConfig *config
+
+// getConfig loads the config once per process. A missing file is not an
+// error; an unreadable one is reported and ignored.
+func getConfig() *config {
+	if loadedConfig != nil {
+		return loadedConfig
+	}
+
+	cfg := &config{}
+	if nvuHome, err := getNvuHome(); err == nil {
+		configPath := filepath.Join(nvuHome, "config.json")
+		if content, err := os.ReadFile(configPath); err == nil {
+			if err := json.Unmarshal(content, cfg); err != nil {
+				fmt.Fprintf(os.Stderr, "nvu warning: ignoring invalid %s: %s\n", configPath, err)
+				cfg = &config{}
+			}
+		}
+	}
+
+	if value := os.Getenv("NVU_SELECT"); value != "" {
+		cfg.Select = value
+	}
+	if value := os.Getenv("NVU_VERSION_FILES"); value != "" {
+		cfg.VersionFiles = splitList(value)
+	}
+	if value := os.Getenv("NVU_ENGINES"); value != "" {
+		cfg.Engines = value
+	}
+	if value := os.Getenv("NVU_NODE_VERSION_ENV"); value != "" {
+		cfg.NodeVersionEnv = isTruthy(value)
+	}
+	if value := os.Getenv("NVU_CEILING_DIRECTORIES"); value != "" {
+		cfg.CeilingDirectories = filepath.SplitList(value)
+	}
+	if value := os.Getenv("NVU_STOP_AT_GIT_ROOT"); value != "" {
+		cfg.StopAtGitRoot = isTruthy(value)
+	}
+	if value := os.Getenv("NVU_INCLUDE_NODE_MODULES"); value != "" {
+		cfg.IncludeNodeModules = isTruthy(value)
+	}
+	if value := os.Getenv("NVU_CWD"); value != "" {
+		cfg.Cwd = value
+	}
+	if value := os.Getenv("NVU_NO_RESOLVE_CACHE"); value != "" {
+		cfg.NoResolveCache = isTruthy(value)
+	}
+	if value := os.Getenv("NVU_STRICT"); value != "" {
+		cfg.Strict = isTruthy(value)
+	}
+	if value := os.Getenv("NVU_WARN_FALLBACK"); value != "" {
+		cfg.WarnFallback = isTruthy(value)
+	}
+	if value := os.Getenv("NVU_AUTO_INSTALL"); value != "" {
+		cfg.AutoInstall = isTruthy(value)
+	}
+	if value := os.Getenv("NVU_RESOLVE_FROM"); value != "" {
+		cfg.ResolveFrom = value
+	}
+	if value := os.Getenv("NVU_FALLBACK"); value != "" {
+		cfg.Fallback = value
+		cfg.FallbackFromEnv = true
+	}
+	if value := os.Getenv("NVU_PIN"); value != "" {
+		cfg.NoPin = !isTruthy(value)
+	}
+	if value := os.Getenv("NVU_HOOK_TIMEOUT"); value != "" {
+		cfg.HookTimeout = value
+	}
+	if value := os.Getenv("NVU_PROJECT_HOOKS"); value != "" {
+		cfg.ProjectHooks = isTruthy(value)
+	}
+	if value := os.Getenv("NVU_MAX_SHIM_HOPS"); value != "" {
+		if limit, err := strconv.Atoi(value); err == nil {
+			cfg.MaxShimHops = limit
+		}
+	}
+	if len(cfg.VersionFiles) == 0 {
+		cfg.VersionFiles = defaultVersionFiles
+	}
+	cfg.VersionFiles = withEnginesSource(cfg.VersionFiles, cfg.Engines)
+
+	loadedConfig = cfg
+	return cfg
+}
+
+// splitList splits a comma-separated setting, dropping empty entries
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// withEnginesSource adds the package.json engines.node entry to the version
+// files list at the configured precedence, unless it is already listed
+func withEnginesSource(versionFiles []string, engines string) []string {
+	for _, name := range versionFiles {
+		if name == enginesNodeSource {
+			return versionFiles
+		}
+	}
+
+	switch engines {
+	case "before":
+		return append([]string{enginesNodeSource}, versionFiles...)
+	case "after":
+		return append(append([]string{}, versionFiles...), enginesNodeSource)
+	}
+	return versionFiles
+}
+
+// isTruthy interprets boolean settings given as environment variables
+func isTruthy(value string) bool {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "1", "true", "yes", "on":
+		return true
+	}
+	return false
+}
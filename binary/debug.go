@@ -0,0 +1,18 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// isDebug reports whether NVU_DEBUG asks the shim to explain what it does
+func isDebug() bool {
+	return isTruthy(os.Getenv("NVU_DEBUG"))
+}
+
+// debugf prints a line of debug output to stderr when NVU_DEBUG is set
+func debugf(format string, args ...interface{}) {
+	if isDebug() {
+		fmt.Fprintf(os.Stderr, "nvu debug: "+format+"\n", args...)
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Routing can be switched off so the shims step aside entirely: NVU_DISABLE=1
+// for a shell or a single command, or `off` as the version in a directory's
+// .nvurc. Every shim but nvu then runs the system binary of the same name as
+// if nvu weren't on PATH, without npm global-install interception; nvu keeps
+// working, and `nvu which` says routing is disabled.
+
+// disabledVersion is the version value that turns routing off
+const disabledVersion = "off"
+
+// isDisabledVersion reports whether a resolved version turns routing off,
+// which only a .nvurc can do
+func isDisabledVersion(version string, source versionSource) bool {
+	return version == disabledVersion && source.env == "" && filepath.Base(source.path) == projectSettingsFile
+}
+
+// isRoutingDisabled reports whether NVU_DISABLE turns the shims off
+func isRoutingDisabled() bool {
+	return isTruthy(os.Getenv("NVU_DISABLE"))
+}
+
+// runPassthrough runs the system binary for execName with the original
+// arguments and environment. It never returns.
+func runPassthrough(execName string, reason string) {
+	debugf("routing disabled by %s, passing %s through to the system binary", reason, execName)
+	systemBinary := resolveSystemBinary(execName)
+	if systemBinary == "" {
+		fmt.Fprintf(os.Stderr, "nvu error: nvu is disabled (by %s) and no system %s was found\n", reason, execName)
+		os.Exit(1)
+	}
+
+	debugf("exec %s", systemBinary)
+	if err := execBinary(systemBinary, os.Args); err != nil {
+		fmt.Fprintf(os.Stderr, "nvu error: failed to exec system %s: %s\n", execName, err)
+		os.Exit(1)
+	}
+	os.Exit(0) // execBinary replaces the process on Unix
+}
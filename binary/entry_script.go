@@ -0,0 +1,114 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// With resolveFrom set to "script", the node shim resolves the version from
+// the directory of the script it runs rather than the current directory, so
+// `node ~/projects/api/scripts/migrate.js` from $HOME uses the api project's
+// version, and so do `#!/usr/bin/env node` tools, which the kernel runs as
+// `node /path/to/tool`. Invocations without a script (node -e, the REPL,
+// reading stdin) still resolve from the current directory.
+
+// nodeValueOptions are node options that take their value as the next
+// argument when it isn't attached with '='. The table matches the options of
+// Node 22.9 (the release that added --env-file-if-exists), plus
+// --experimental-policy from older lines. An option missing from it is taken
+// for a flag, so its value would be mistaken for the script: newer options
+// that take a value belong here.
+var nodeValueOptions = map[string]bool{
+	"-r": true, "--require": true, "--import": true, "--loader": true, "--experimental-loader": true,
+	"-C": true, "--conditions": true, "--input-type": true, "--title": true, "--env-file": true,
+	"--env-file-if-exists": true, "--experimental-default-type": true, "--experimental-policy": true,
+	"--icu-data-dir": true, "--openssl-config": true, "--redirect-warnings": true, "--disable-warning": true,
+	"--dns-result-order": true, "--unhandled-rejections": true, "--diagnostic-dir": true,
+	"--cpu-prof-dir": true, "--cpu-prof-name": true, "--cpu-prof-interval": true,
+	"--heap-prof-dir": true, "--heap-prof-name": true, "--heap-prof-interval": true,
+	"--report-dir": true, "--report-directory": true, "--report-filename": true, "--report-signal": true,
+	"--max-http-header-size": true, "--secure-heap": true, "--secure-heap-min": true,
+	"--stack-trace-limit": true, "--title-prefix": true, "--tls-cipher-list": true, "--tls-keylog": true,
+	"--use-largepages": true, "--v8-pool-size": true, "--watch-path": true, "--snapshot-blob": true,
+	"--allow-fs-read": true, "--allow-fs-write": true, "--test-name-pattern": true,
+	"--test-reporter": true, "--test-reporter-destination": true, "--test-shard": true,
+	"--test-concurrency": true, "--test-timeout": true, "--trace-event-categories": true,
+	"--trace-event-file-pattern": true,
+}
+
+// nodeScriptlessOptions make node run something other than an entry script
+var nodeScriptlessOptions = map[string]bool{
+	"-e": true, "--eval": true, "-p": true, "--print": true, "-i": true, "--interactive": true,
+	"--run": true, "--test": true, "-v": true, "--version": true, "-h": true, "--help": true,
+}
+
+// findEntryScript returns the script node would run for these arguments, or
+// "" if there is none
+func findEntryScript(args []string) string {
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--":
+			if i+1 < len(args) {
+				return args[i+1]
+			}
+			return ""
+		case arg == "-":
+			return "" // script read from stdin
+		case nodeScriptlessOptions[arg]:
+			return ""
+		case strings.HasPrefix(arg, "--"):
+			name, _, hasValue := strings.Cut(arg, "=")
+			if nodeScriptlessOptions[name] {
+				return ""
+			}
+			if !hasValue && nodeValueOptions[name] {
+				i++
+			}
+		case strings.HasPrefix(arg, "-"):
+			// short options can be grouped, as in node -pe 'code'
+			if strings.ContainsAny(arg[1:], "epi") {
+				return ""
+			}
+			if nodeValueOptions[arg] {
+				i++
+			}
+		default:
+			return arg
+		}
+	}
+	return ""
+}
+
+// getEntryScriptDirectory returns the directory of the entry script in the
+// node arguments, following symlinks as node does for the main module unless
+// --preserve-symlinks-main is given. Returns "" if there is no usable script.
+func getEntryScriptDirectory(args []string) string {
+	script := findEntryScript(args)
+	if script == "" {
+		return ""
+	}
+	script, err := filepath.Abs(script)
+	if err != nil {
+		return ""
+	}
+
+	preserveSymlinks := false
+	for _, arg := range args {
+		if arg == "--preserve-symlinks-main" {
+			preserveSymlinks = true
+		}
+	}
+	if !preserveSymlinks {
+		if real, err := filepath.EvalSymlinks(script); err == nil {
+			script = real
+		}
+	}
+
+	dir := filepath.Dir(script)
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		return ""
+	}
+	return dir
+}
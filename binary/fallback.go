@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Fallback policies for a configured version that isn't installed
+const (
+	fallbackFail          = "fail"           // report it (default)
+	fallbackSameMinor     = "same-minor"     // an installed version with the same major.minor
+	fallbackSameMajor     = "same-major"     // an installed version with the same major
+	fallbackNearestHigher = "nearest-higher" // the lowest installed version above it
+	fallbackDefault       = "default"        // the global default
+)
+
+// getFallbackPolicy returns the fallback policy: NVU_FALLBACK, then the
+// nearest .nvurc, then ~/.nvu/config.json
+func getFallbackPolicy() string {
+	cfg := getConfig()
+	if cfg.FallbackFromEnv {
+		return cfg.Fallback
+	}
+	if value := getProjectSetting("fallback"); value != "" {
+		return value
+	}
+	return cfg.Fallback
+}
+
+// applyFallbackPolicy substitutes an installed version for one that isn't
+// installed, as the fallback policy allows, with a note on stderr saying
+// what was substituted and why. Without a usable substitute the version is
+// returned unchanged and fails as usual.
+func applyFallbackPolicy(version string, source versionSource) string {
+	if version == "" || version == "system" || version == "engines" || version == disabledVersion || isVersionInstalled(version) {
+		return version
+	}
+	policy := getFallbackPolicy()
+	if policy == "" || policy == fallbackFail {
+		return version
+	}
+
+	substitute, err := findFallbackVersion(version, policy)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "nvu warning: %s\n", err)
+		return version
+	}
+	if substitute == "" {
+		return version
+	}
+
+	markUncacheable()
+	fmt.Fprintf(os.Stderr, "nvu note: Node %s (from %s) is not installed, using %s instead (fallback: %s)\n", version, source, substitute, policy)
+	return substitute
+}
+
+// findFallbackVersion returns the installed version a policy substitutes for
+// version, or "" if there is none
+func findFallbackVersion(version string, policy string) (string, error) {
+	if policy == fallbackDefault {
+		defaultVersion, _, err := readDefaultVersion()
+		if err != nil {
+			return "", nil
+		}
+		if defaultVersion, err = expandAlias(defaultVersion); err != nil {
+			return "", nil
+		}
+		defaultVersion = selectFromList(defaultVersion)
+		if !isVersionInstalled(defaultVersion) {
+			return "", nil
+		}
+		return defaultVersion, nil
+	}
+
+	// the other policies measure distance from a plain version, except that
+	// nearest-higher also takes a range, from the lowest version it allows
+	p, ok := parsePartialVersion(version)
+	var lower semver
+	if ok {
+		lower = xRange{parts: p.parts, prerelease: p.prerelease}.lower()
+	} else if r, isRange := parseRange(version); isRange && policy == fallbackNearestHigher {
+		lower = r.lower()
+	} else {
+		return "", nil
+	}
+	nvuHome, err := getNvuHome()
+	if err != nil {
+		return "", nil
+	}
+	versionsDir := filepath.Join(nvuHome, "installed")
+	installed, err := listInstalledVersions(versionsDir)
+	if err != nil {
+		return "", nil
+	}
+
+	var matches []installedVersion
+	switch policy {
+	case fallbackSameMinor:
+		if len(p.parts) < 2 {
+			return "", nil
+		}
+		for _, iv := range installed {
+			if !iv.version.isPrerelease() && iv.version.major == p.parts[0] && iv.version.minor == p.parts[1] {
+				matches = append(matches, iv)
+			}
+		}
+	case fallbackSameMajor:
+		for _, iv := range installed {
+			if !iv.version.isPrerelease() && iv.version.major == p.parts[0] {
+				matches = append(matches, iv)
+			}
+		}
+	case fallbackNearestHigher:
+		for _, iv := range installed {
+			if !iv.version.isPrerelease() && compareSemver(iv.version, lower) > 0 {
+				return iv.name, nil // installed versions are sorted ascending
+			}
+		}
+		return "", nil
+	default:
+		return "", fmt.Errorf("unknown fallback policy %q (use fail, same-minor, same-major, nearest-higher or default)", policy)
+	}
+
+	if len(matches) == 0 {
+		return "", nil
+	}
+	return selectVersion(versionsDir, matches, getConfig().Select), nil
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"time"
+)
+
+// Hooks are executables the shim runs around a tool: ~/.nvu/hooks/pre-exec
+// and post-exec, then the ones a project's .nvurc names with
+// `pre-exec = <path>` and `post-exec = <path>` (relative to the .nvurc, and
+// only with projectHooks on). They get the invocation in NVU_HOOK_* variables.
+// A pre-exec hook that fails or times out aborts the command with its exit
+// code (128+n if it died from signal n, 124 on timeout, which kills the
+// hook's whole process group); post-exec failures are only reported. Hooks run with NVU_HOOK set, and
+// shims that see it skip hooks, so a hook can run node or npm without looping.
+
+// Hook names, also the file names under ~/.nvu/hooks and the .nvurc keys
+const (
+	preExecHook  = "pre-exec"
+	postExecHook = "post-exec"
+)
+
+// hookEnv is set to the running hook's name; shims skip hooks while it is set
+const hookEnv = "NVU_HOOK"
+
+// defaultHookTimeout applies when hookTimeout isn't set
+const defaultHookTimeout = 10 * time.Second
+
+// exitHookTimeout is the exit code of a command aborted by a pre-exec hook
+// timing out, as with timeout(1)
+const exitHookTimeout = 124
+
+// hookContext describes the command hooks run around
+type hookContext struct {
+	command string            // the shim's name, e.g. "npm"
+	version string            // the version expression the command runs under
+	source  versionSource     // where the version came from
+	binary  string            // the binary the shim runs
+	env     map[string]string // environment overrides the command runs with
+}
+
+// execWithHooks runs the pre-exec hooks and then the command. Without
+// post-exec hooks the command replaces the shim as usual; with them the shim
+// supervises it, runs them and exits with its exit code.
+func execWithHooks(h hookContext) error {
+	h.runPreExec()
+	if len(h.findHooks(postExecHook)) == 0 {
+		return execBinaryWithEnv(h.binary, os.Args, h.env)
+	}
+
+	cmd := exec.Command(h.binary, os.Args[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = buildEnv(guardShimLoop(h.binary, h.env))
+	exitCode, err := runSupervised(cmd)
+	if err != nil {
+		return err
+	}
+	h.runPostExec(exitCode)
+	os.Exit(exitCode)
+	return nil
+}
+
+// runPreExec runs the pre-exec hooks, exiting if one of them fails
+func (h hookContext) runPreExec() {
+	for _, hook := range h.findHooks(preExecHook) {
+		exitCode, err := h.runHook(hook, preExecHook, nil)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "nvu error: pre-exec hook %s %s, not running %s\n", hook, err, h.command)
+			os.Exit(exitCode)
+		}
+	}
+}
+
+// runPostExec runs the post-exec hooks, warning about any that fail
+func (h hookContext) runPostExec(exitCode int) {
+	for _, hook := range h.findHooks(postExecHook) {
+		if _, err := h.runHook(hook, postExecHook, &exitCode); err != nil {
+			fmt.Fprintf(os.Stderr, "nvu warning: post-exec hook %s %s\n", hook, err)
+		}
+	}
+}
+
+// findHooks returns the global and then the project hooks called name. There
+// are none inside a hook.
+func (h hookContext) findHooks(name string) []string {
+	if os.Getenv(hookEnv) != "" {
+		return nil
+	}
+
+	var hooks []string
+	if nvuHome, err := getNvuHome(); err == nil {
+		if hook := findHookExecutable(filepath.Join(nvuHome, "hooks", name)); hook != "" {
+			hooks = append(hooks, hook)
+		}
+	}
+
+	if project, dir := getProject(); project != nil && project.settings[name] != "" {
+		hook := project.settings[name]
+		if !filepath.IsAbs(hook) {
+			hook = filepath.Join(dir, hook)
+		}
+		if getConfig().ProjectHooks {
+			hooks = append(hooks, hook)
+		} else {
+			debugf("skipping %s hook %s from %s: project hooks are off", name, hook, filepath.Join(dir, projectSettingsFile))
+		}
+	}
+	return hooks
+}
+
+// findHookExecutable returns the hook at path, trying the executable
+// extensions on Windows, or "" if there is none
+func findHookExecutable(path string) string {
+	candidates := []string{path}
+	if runtime.GOOS == "windows" {
+		candidates = []string{path + ".exe", path + ".cmd", path + ".bat"}
+	}
+	for _, candidate := range candidates {
+		if info, err := os.Stat(candidate); err == nil && !info.IsDir() {
+			return candidate
+		}
+	}
+	return ""
+}
+
+// runHook runs one hook with the command described in its environment. The
+// error says why the hook failed, and the exit code is the one to abort with.
+func (h hookContext) runHook(hook string, name string, exitCode *int) (int, error) {
+	timeout := getHookTimeout()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	args, _ := json.Marshal(os.Args[1:])
+	env := map[string]string{
+		hookEnv:              name,
+		"NVU_HOOK_COMMAND":   h.command,
+		"NVU_HOOK_VERSION":   h.resolvedVersion(),
+		"NVU_HOOK_SOURCE":    h.source.String(),
+		"NVU_HOOK_BINARY":    h.binary,
+		"NVU_HOOK_ARGS":      string(args),
+		"NVU_HOOK_EXIT_CODE": "",
+	}
+	if exitCode != nil {
+		env["NVU_HOOK_EXIT_CODE"] = strconv.Itoa(*exitCode)
+	}
+	for key, value := range h.env {
+		env[key] = value
+	}
+
+	debugf("running %s hook %s", name, hook)
+	// on timeout, kill whatever the hook started too, not just the hook
+	cmd := exec.CommandContext(ctx, hook)
+	setNewProcessGroup(cmd)
+	cmd.Cancel = func() error { return killProcessGroup(cmd) }
+	cmd.Stdout = os.Stderr // keep the command's own stdout clean
+	cmd.Stderr = os.Stderr
+	cmd.Env = buildEnv(env)
+	err := cmd.Start()
+	if err == nil {
+		// a group kill that reaches the shim reaches the hook's group too
+		stopForwarding := forwardSignalsToGroup(cmd)
+		err = cmd.Wait()
+		stopForwarding()
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		return exitHookTimeout, fmt.Errorf("timed out after %s", timeout)
+	}
+	var exitError *exec.ExitError
+	if errors.As(err, &exitError) {
+		exitCode := exitStatus(exitError.ProcessState)
+		return exitCode, fmt.Errorf("exited with code %d", exitCode)
+	}
+	if err != nil {
+		return 1, fmt.Errorf("could not be run: %s", err)
+	}
+	return 0, nil
+}
+
+// resolvedVersion returns the installed version the command runs under, e.g.
+// "v20.11.0", or the version expression if it doesn't name one
+func (h hookContext) resolvedVersion() string {
+	if h.version == "system" {
+		return h.version
+	}
+	nvuHome, err := getNvuHome()
+	if err != nil {
+		return h.version
+	}
+	resolved, err := resolveInstalledVersion(filepath.Join(nvuHome, "installed"), h.version)
+	if err != nil {
+		return h.version
+	}
+	return resolved
+}
+
+// getHookTimeout returns how long a hook may run
+func getHookTimeout() time.Duration {
+	value := getConfig().HookTimeout
+	if value == "" {
+		return defaultHookTimeout
+	}
+	timeout, err := time.ParseDuration(value)
+	if err != nil || timeout <= 0 {
+		fmt.Fprintf(os.Stderr, "nvu warning: invalid hookTimeout %q, using %s\n", value, defaultHookTimeout)
+		return defaultHookTimeout
+	}
+	return timeout
+}
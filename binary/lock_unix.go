@@ -0,0 +1,34 @@
+//go:build !windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// tryLockFile takes an exclusive flock on path, creating the file if needed,
+// and reports false while another process holds it. The kernel drops the
+// lock when its holder dies, so a killed install never leaves it behind.
+func tryLockFile(path string) (func(), bool, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, false, err
+	}
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		file.Close()
+		if err == syscall.EWOULDBLOCK {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+
+	// the pid tells a waiting shim whether the holder is its own ancestor
+	file.Truncate(0)
+	fmt.Fprintf(file, "%d\n", os.Getpid())
+	return func() {
+		syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+		file.Close()
+	}, true, nil
+}
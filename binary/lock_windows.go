@@ -0,0 +1,36 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// errorSharingViolation is ERROR_SHARING_VIOLATION, which syscall doesn't name
+const errorSharingViolation syscall.Errno = 32
+
+// tryLockFile opens path shared for reading only, creating the file if
+// needed, and reports false while another process has it open. Windows
+// closes the handle when its holder dies, so a killed install never leaves
+// it behind.
+func tryLockFile(path string) (func(), bool, error) {
+	name, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return nil, false, err
+	}
+	handle, err := syscall.CreateFile(name, syscall.GENERIC_READ|syscall.GENERIC_WRITE, syscall.FILE_SHARE_READ, nil, syscall.OPEN_ALWAYS, syscall.FILE_ATTRIBUTE_NORMAL, 0)
+	if err == errorSharingViolation {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	// the pid tells a waiting shim whether the holder is its own ancestor
+	file := os.NewFile(uintptr(handle), path)
+	file.Truncate(0)
+	fmt.Fprintf(file, "%d\n", os.Getpid())
+	return func() { file.Close() }, true, nil
+}
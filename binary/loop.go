@@ -0,0 +1,111 @@
+package main
+
+import (
+	"debug/buildinfo"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"strconv"
+	"strings"
+)
+
+// A shim that runs another shim instead of a real binary can loop forever:
+// an installed/<v>/bin/node that is a copy of the shim, or a second nvu home
+// on PATH whose shims hand the command back, or a wrapper script that calls
+// the shim again with or without exec. Two guards stop that. Before running a
+// binary, the shim checks that it isn't a build of the shim itself. It also
+// counts the binaries run by shims in the environment, which everything
+// started below inherits, and gives up after maxShimHops (configurable),
+// naming the last ones. The count ignores arguments and directories, so a
+// loop is caught even when its arguments change every hop, and nesting such
+// as npm scripts that run npm in each workspace is fine up to the limit.
+
+// Environment variables that carry the shim hops above a process
+const (
+	shimHopsEnv  = "NVU_SHIM_HOPS"  // how many binaries shims have run
+	shimChainEnv = "NVU_SHIM_CHAIN" // the last of them, one per line, oldest first
+)
+
+// defaultMaxShimHops is how many shim hops may nest when maxShimHops isn't
+// configured
+const defaultMaxShimHops = 32
+
+// maxShimChain bounds the binaries kept in the environment for the error
+const maxShimChain = 16
+
+// guardShimLoop is called just before the shim runs target, replacing itself
+// with it or spawning it. It exits if target is a shim or too many shim hops
+// are nested above it; otherwise it returns env extended with the count.
+func guardShimLoop(target string, env map[string]string) map[string]string {
+	checkNotShim(target)
+
+	hops, _ := strconv.Atoi(os.Getenv(shimHopsEnv))
+	hops++
+	chain := append(getShimChain(), target)
+	if limit := getMaxShimHops(); hops > limit {
+		fmt.Fprintf(os.Stderr, "nvu error: shim loop: shims have run %d binaries below one another, more than the %d allowed (maxShimHops). The last ones:\n", hops, limit)
+		for _, hop := range chain {
+			fmt.Fprintf(os.Stderr, "  → %s\n", hop)
+		}
+		selfPath, _ := os.Executable()
+		fmt.Fprintf(os.Stderr, "\nOne of these runs the shim again. Check PATH for another nvu home or a wrapper that calls back into %s.\n", filepath.Dir(selfPath))
+		os.Exit(1)
+	}
+
+	if len(chain) > maxShimChain {
+		chain = chain[len(chain)-maxShimChain:]
+	}
+	guarded := make(map[string]string)
+	for key, value := range env {
+		guarded[key] = value
+	}
+	guarded[shimHopsEnv] = strconv.Itoa(hops)
+	guarded[shimChainEnv] = strings.Join(chain, "\n")
+	return guarded
+}
+
+// getMaxShimHops returns the configured hop limit, or the default
+func getMaxShimHops() int {
+	if limit := getConfig().MaxShimHops; limit > 0 {
+		return limit
+	}
+	return defaultMaxShimHops
+}
+
+// getShimChain returns the last binaries run by the shims above this one
+func getShimChain() []string {
+	value := os.Getenv(shimChainEnv)
+	if value == "" {
+		return nil
+	}
+	return strings.Split(value, "\n")
+}
+
+// checkNotShim exits with an explanation if path is a build of the shim,
+// which would run the shim again instead of the binary it stands for
+func checkNotShim(path string) {
+	if !isShimBinary(path) {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "nvu error: %s is a copy of the nvu shim, not the real %s; running it would loop\n", path, getBaseName(filepath.Base(path)))
+	if nvuHome, err := getNvuHome(); err == nil {
+		if rel, err := filepath.Rel(filepath.Join(nvuHome, "installed"), path); err == nil && !strings.HasPrefix(rel, "..") {
+			version := strings.Split(filepath.ToSlash(rel), "/")[0]
+			fmt.Fprintf(os.Stderr, "\nReinstall that version: nvu uninstall %s && nvu install %s\n", version, version)
+		}
+	}
+	os.Exit(1)
+}
+
+// isShimBinary reports whether path is a build of this shim, by comparing
+// the main package recorded in both binaries. Any version of the shim
+// matches, so a second nvu home's shims are recognised too.
+func isShimBinary(path string) bool {
+	self, ok := debug.ReadBuildInfo()
+	if !ok || self.Path == "" {
+		return false
+	}
+	info, err := buildinfo.ReadFile(path)
+	return err == nil && info.Path == self.Path
+}
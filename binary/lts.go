@@ -0,0 +1,220 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// LTS aliases are resolved offline from a cached copy of the Node release
+// index (https://nodejs.org/dist/index.json) at ~/.nvu/cache/index.json,
+// which the CLI refreshes on every `nvu install`:
+//   lts, lts/*    newest LTS line
+//   lts/iron      LTS line by codename (case-insensitive)
+//   lts/-1        LTS line before the newest (lts/-2 the one before that, ...)
+
+// releaseIndexEntry is the part of a Node release index entry the binary needs
+type releaseIndexEntry struct {
+	Version string          `json:"version"`
+	LTS     json.RawMessage `json:"lts"` // codename string, or false
+}
+
+// releaseIndex maps versions (without 'v') to their LTS codename ("" if not LTS)
+type releaseIndex struct {
+	codenames map[string]string
+	// lines holds LTS codenames newest first, with the major each belongs to
+	lines []ltsLine
+}
+
+type ltsLine struct {
+	codename string
+	major    int
+}
+
+var loadedReleaseIndex *releaseIndex
+var releaseIndexErr error
+
+// getReleaseIndexPath returns the location of the cached release index
+func getReleaseIndexPath() (string, error) {
+	nvuHome, err := getNvuHome()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(nvuHome, "cache", "index.json"), nil
+}
+
+// loadReleaseIndex reads the cached release index once per process
+func loadReleaseIndex() (*releaseIndex, error) {
+	if loadedReleaseIndex != nil || releaseIndexErr != nil {
+		return loadedReleaseIndex, releaseIndexErr
+	}
+
+	indexPath, err := getReleaseIndexPath()
+	if err != nil {
+		releaseIndexErr = err
+		return nil, err
+	}
+
+	content, err := readSourceFile(indexPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			releaseIndexErr = &releaseIndexMissingError{path: indexPath}
+		} else {
+			releaseIndexErr = fmt.Errorf("failed to read %s: %w", indexPath, err)
+		}
+		return nil, releaseIndexErr
+	}
+
+	var entries []releaseIndexEntry
+	if err := json.Unmarshal(content, &entries); err != nil {
+		releaseIndexErr = fmt.Errorf("invalid release index %s: %w", indexPath, err)
+		return nil, releaseIndexErr
+	}
+
+	index := &releaseIndex{codenames: make(map[string]string)}
+	seen := make(map[string]bool)
+	for _, entry := range entries {
+		v, ok := parseSemver(entry.Version)
+		if !ok {
+			continue
+		}
+		codename := ""
+		_ = json.Unmarshal(entry.LTS, &codename) // false for non-LTS releases
+		index.codenames[v.String()] = codename
+
+		// the index is ordered newest first, so lines come out newest first too
+		key := strings.ToLower(codename)
+		if codename != "" && !seen[key] {
+			seen[key] = true
+			index.lines = append(index.lines, ltsLine{codename: codename, major: v.major})
+		}
+	}
+
+	loadedReleaseIndex = index
+	return index, nil
+}
+
+// releaseIndexMissingError reports that the release index isn't cached, so
+// no LTS alias can be resolved until nvu downloads it again
+type releaseIndexMissingError struct {
+	path string
+}
+
+func (e *releaseIndexMissingError) Error() string {
+	return fmt.Sprintf("Node release index not cached at %s (run: nvu install lts to refresh it)", e.path)
+}
+
+// isReleaseIndexMissing reports whether err comes from a missing release index
+func isReleaseIndexMissing(err error) bool {
+	var missing *releaseIndexMissingError
+	return errors.As(err, &missing)
+}
+
+// isLTSAlias reports whether version is one of the lts/ aliases
+func isLTSAlias(version string) bool {
+	lower := strings.ToLower(version)
+	return lower == "lts" || strings.HasPrefix(lower, "lts/")
+}
+
+// resolveLTSAlias resolves an lts/ alias to the best installed version of
+// the LTS line it names
+func resolveLTSAlias(versionsDir string, alias string) (string, error) {
+	index, err := loadReleaseIndex()
+	if err != nil {
+		return "", fmt.Errorf("cannot resolve %s: %w", alias, err)
+	}
+
+	line, err := index.findLine(alias)
+	if err != nil {
+		return "", err
+	}
+
+	installed, err := listInstalledVersions(versionsDir)
+	if err != nil {
+		return "", fmt.Errorf("failed to read versions directory: %w", err)
+	}
+
+	var matches []installedVersion
+	for _, iv := range installed {
+		if iv.version.major == line.major && strings.EqualFold(installedLTSCodename(versionsDir, iv), line.codename) {
+			matches = append(matches, iv)
+		}
+	}
+
+	if len(matches) == 0 {
+		return "", fmt.Errorf("no installed version matching %s (%s, Node %d)", alias, line.codename, line.major)
+	}
+	return selectVersion(versionsDir, matches, getConfig().Select), nil
+}
+
+// findLine returns the LTS line named by an alias
+func (index *releaseIndex) findLine(alias string) (ltsLine, error) {
+	if len(index.lines) == 0 {
+		return ltsLine{}, fmt.Errorf("cannot resolve %s: no LTS releases in the cached release index", alias)
+	}
+
+	name := ""
+	if i := strings.IndexByte(alias, '/'); i >= 0 {
+		name = alias[i+1:]
+	}
+
+	switch {
+	case name == "" || name == "*":
+		return index.lines[0], nil
+	case strings.HasPrefix(name, "-"):
+		offset, err := strconv.Atoi(name[1:])
+		if err != nil || offset < 0 {
+			return ltsLine{}, fmt.Errorf("invalid LTS alias: %s", alias)
+		}
+		if offset >= len(index.lines) {
+			return ltsLine{}, fmt.Errorf("cannot resolve %s: only %d LTS lines in the cached release index", alias, len(index.lines))
+		}
+		return index.lines[offset], nil
+	}
+
+	for _, line := range index.lines {
+		if strings.EqualFold(line.codename, name) {
+			return line, nil
+		}
+	}
+	return ltsLine{}, fmt.Errorf("unknown LTS codename in %s", alias)
+}
+
+// installedLTSCodename returns the LTS codename of an installed version, or ""
+// if it isn't an LTS release. The cached release index is checked first; the
+// headers shipped with the install cover versions newer than the cache.
+func installedLTSCodename(versionsDir string, iv installedVersion) string {
+	if index, err := loadReleaseIndex(); err == nil {
+		if codename, ok := index.codenames[iv.version.String()]; ok {
+			return codename
+		}
+	}
+
+	defines := readNodeVersionHeader(versionsDir, iv.name)
+	if defines["NODE_VERSION_IS_LTS"] != "1" {
+		return ""
+	}
+	return strings.Trim(defines["NODE_VERSION_LTS_CODENAME"], "\"")
+}
+
+// readNodeVersionHeader returns the #defines in include/node/node_version.h
+// of an installed version, or nil if it has no headers (e.g. on Windows)
+func readNodeVersionHeader(versionsDir string, name string) map[string]string {
+	header := filepath.Join(versionsDir, name, "include", "node", "node_version.h")
+	content, err := os.ReadFile(header)
+	if err != nil {
+		return nil
+	}
+	defines := make(map[string]string)
+	for _, line := range strings.Split(string(content), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 3 && fields[0] == "#define" {
+			defines[fields[1]] = fields[2]
+		}
+	}
+	return defines
+}
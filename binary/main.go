@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
@@ -11,9 +12,13 @@ import (
 )
 
 // Version resolution priority:
-// 1. .nvurc in current or parent directories
-// 2. .nvmrc in current or parent directories
-// 3. ~/.nvu/default (global default)
+// 0. NVU_VERSION environment variable (or NODE_VERSION, when opted in)
+//    then a version pinned by a parent shim (NVU_RESOLVED_VERSION)
+// 1. .nvurc, .nvmrc, .node-version, .tool-versions, or package.json
+//    volta.node / devEngines.runtime in current or parent directories (the
+//    sources and their order are configurable, and package.json engines.node
+//    can be opted in)
+// 2. ~/.nvu/default (global default)
 
 // getNvuHome returns the nvu home directory, respecting NVU_HOME env var
 func getNvuHome() (string, error) {
@@ -39,15 +44,35 @@ func main() {
 		return
 	}
 
+	// NVU_DISABLE turns every other shim into its system binary
+	if isRoutingDisabled() {
+		runPassthrough(execName, "NVU_DISABLE")
+	}
+
 	// Core binaries that always exist in Node installations
 	isCoreNodeBinary := execName == "node" || execName == "npm" || execName == "npx"
 
+	// Optionally resolve from the entry script's directory rather than the cwd
+	if execName == "node" && getConfig().ResolveFrom == "script" {
+		startDirectory = getEntryScriptDirectory(os.Args[1:])
+	}
+
 	// Resolve the Node version to use
-	version, err := resolveVersion()
+	installMissingVersions = isCoreNodeBinary
+	version, source, err := resolveVersionSource()
+	if isDisabledVersion(version, source) {
+		runPassthrough(execName, source.String())
+	}
+	if isSourceError(err) {
+		// A version file demands a version that can't be used - don't fall back
+		fmt.Fprintf(os.Stderr, "nvu error: %s\n", err)
+		os.Exit(1)
+	}
 	if err != nil {
 		// No version configured - try system binary as fallback
 		systemBinary := resolveSystemBinary(execName)
 		if systemBinary != "" {
+			reportFallback(exitNoVersion, fmt.Sprintf("%s, using system %s (%s)", err, execName, systemBinary))
 			err = execBinary(systemBinary, os.Args)
 			if err != nil {
 				fmt.Fprintf(os.Stderr, "nvu error: failed to exec system %s: %s\n", execName, err)
@@ -65,12 +90,13 @@ func main() {
 
 	// Handle "system" version - use system binary directly
 	if version == "system" {
+		saveResolutionCache()
 		systemBinary := resolveSystemBinary(execName)
 		if systemBinary == "" {
 			fmt.Fprintf(os.Stderr, "nvu error: system %s not found\n", execName)
 			os.Exit(1)
 		}
-		err = execBinary(systemBinary, os.Args)
+		err = execWithHooks(hookContext{command: execName, version: version, source: source, binary: systemBinary, env: getProjectEnv(nil)})
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "nvu error: failed to exec system %s: %s\n", execName, err)
 			os.Exit(1)
@@ -80,15 +106,22 @@ func main() {
 
 	// Find the real binary path
 	binaryPath, err := findBinary(execName, version)
+	saveResolutionCache()
+	if err != nil && isCoreNodeBinary && autoInstall(version, source) {
+		binaryPath, err = findBinary(execName, version)
+	}
 	if err != nil {
 		// For non-core binaries, route to default version's bin directory
 		if !isCoreNodeBinary {
-			binaryPath, err = routeToDefaultBinary(execName)
+			binaryPath, err = routeToDefaultBinary(execName, version)
 		}
 		if err != nil {
 			if isCoreNodeBinary {
 				fmt.Fprintf(os.Stderr, "nvu error: %s\n", err)
-				fmt.Fprintf(os.Stderr, "\nNode %s may not be installed. Run: nvu install %s\n", version, version)
+				// the error already says how to get the index back
+				if !isReleaseIndexMissing(err) {
+					fmt.Fprintf(os.Stderr, "\nNode %s (from %s) may not be installed. Run: nvu install %s\n", version, source, version)
+				}
 			} else {
 				fmt.Fprintf(os.Stderr, "nvu error: '%s' not found\n", execName)
 			}
@@ -96,88 +129,237 @@ func main() {
 		}
 	}
 
+	// Pin the resolved install for child processes
+	var pinEnv map[string]string
+	if nodePath, err := findBinary("node", version); err == nil {
+		pinEnv = getPinEnv(filepath.Dir(nodePath))
+	}
+	hooks := hookContext{command: execName, version: version, source: source, binary: binaryPath, env: getProjectEnv(pinEnv)}
+
 	// Check if this is npm install/uninstall -g, and if so, handle shim creation/removal after
 	if execName == "npm" {
 		if isGlobalInstall() {
-			runNpmAndCreateShims(binaryPath, os.Args)
-			return
+			hooks.runPreExec()
+			exitCode := runNpmAndCreateShims(binaryPath, os.Args, hooks.env)
+			hooks.runPostExec(exitCode)
+			os.Exit(exitCode)
 		}
 		if isGlobalUninstall() {
-			runNpmAndRemoveShims(binaryPath, os.Args)
-			return
+			hooks.runPreExec()
+			exitCode := runNpmAndRemoveShims(binaryPath, os.Args, hooks.env)
+			hooks.runPostExec(exitCode)
+			os.Exit(exitCode)
 		}
 	}
 
 	// Execute the real binary, replacing this process
-	err = execBinary(binaryPath, os.Args)
+	debugf("exec %s (Node %s from %s)", binaryPath, version, source)
+	err = execWithHooks(hooks)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "nvu error: failed to exec %s: %s\n", binaryPath, err)
 		os.Exit(1)
 	}
 }
 
+// versionSource records where a version expression was read from
+type versionSource struct {
+	path  string // file the version came from
+	line  int    // 1-based line within a plain version file, 0 if not known
+	field string // field within a JSON file (e.g. "volta.node"), "" for plain version files
+	env   string // environment variable the version came from, instead of a file
+}
+
+// String formats the source for messages, e.g. "volta.node in /app/package.json"
+func (s versionSource) String() string {
+	if s.env != "" {
+		return "$" + s.env
+	}
+	if s.field != "" {
+		return s.field + " in " + s.path
+	}
+	if s.line > 0 {
+		return fmt.Sprintf("%s:%d", s.path, s.line)
+	}
+	return s.path
+}
+
+// sourceError is a problem with a version source that must stop resolution
+// rather than fall through to parent directories or the global default
+type sourceError struct {
+	source versionSource
+	err    error
+}
+
+func (e *sourceError) Error() string {
+	return fmt.Sprintf("%s: %s", e.source, e.err)
+}
+
+// isSourceError reports whether err stopped resolution at a version source
+func isSourceError(err error) bool {
+	var srcErr *sourceError
+	return errors.As(err, &srcErr)
+}
+
 // resolveVersion determines which Node version to use
 func resolveVersion() (string, error) {
-	// 1. Check for .nvurc or .nvmrc in current directory and parents
-	cwd, err := os.Getwd()
+	version, _, err := resolveVersionSource()
+	return version, err
+}
+
+// resolveVersionSource determines which Node version to use and where it was configured
+func resolveVersionSource() (string, versionSource, error) {
+	// 0. An environment override beats every version file
+	if version, source := readVersionEnv(); version != "" {
+		version, source, err := expandVersionAlias(version, source)
+		return applyFallbackPolicy(selectFromList(version), source), source, err
+	}
+
+	// A parent shim's pin keeps the whole process tree on one install
+	if version, source := readPinnedVersion(); version != "" {
+		return version, source, nil
+	}
+
+	// 1. Check for version files in current directory and parents
+	cwd, err := getWorkingDirectory()
 	if err != nil {
-		return "", fmt.Errorf("failed to get current directory: %w", err)
+		return "", versionSource{}, fmt.Errorf("failed to get current directory: %w", err)
+	}
+
+	// A cached resolution for this directory skips the walk entirely
+	if entry := loadResolutionCache(cwd); entry != nil {
+		return entry.Version, entry.source(), nil
 	}
 
-	version := findVersionInParents(cwd)
-	if version != "" {
-		return version, nil
+	version, source, err := findVersionInParents(cwd)
+	if err != nil {
+		return "", source, err
 	}
 
 	// 2. Check global default
+	if version == "" {
+		version, source, err = readDefaultVersion()
+		if err != nil {
+			return "", source, err
+		}
+	}
+
+	version, source, err = expandVersionAlias(version, source)
+	if err != nil {
+		return "", source, err
+	}
+	version = applyFallbackPolicy(selectFromList(version), source)
+
+	setPendingResolution(cwd, version, source)
+	return version, source, nil
+}
+
+// readVersionEnv returns the version set by NVU_VERSION, or by NODE_VERSION
+// when nodeVersionEnv is enabled
+func readVersionEnv() (string, versionSource) {
+	names := []string{"NVU_VERSION"}
+	if getConfig().NodeVersionEnv {
+		names = append(names, "NODE_VERSION")
+	}
+	for _, name := range names {
+		if version := strings.TrimSpace(os.Getenv(name)); version != "" {
+			return version, versionSource{env: name}
+		}
+	}
+	return "", versionSource{}
+}
+
+// readDefaultVersion reads the global default from ~/.nvu/default
+func readDefaultVersion() (string, versionSource, error) {
 	nvuHome, err := getNvuHome()
 	if err != nil {
-		return "", fmt.Errorf("failed to get nvu home directory: %w", err)
+		return "", versionSource{}, fmt.Errorf("failed to get nvu home directory: %w", err)
 	}
 
 	defaultPath := filepath.Join(nvuHome, "default")
-	version, err = readVersionFile(defaultPath)
+	version, err := readVersionFile(defaultPath)
+	if isSourceError(err) {
+		return "", versionSource{path: defaultPath}, err
+	}
 	if err == nil && version != "" {
-		return version, nil
+		return version, versionSource{path: defaultPath}, nil
 	}
 
-	return "", fmt.Errorf("no Node version configured")
+	return "", versionSource{}, fmt.Errorf("no Node version configured")
 }
 
-// findVersionInParents walks up the directory tree looking for version config files
-func findVersionInParents(dir string) string {
+// findVersionInParents walks up the directory tree looking for version config
+// files. Unreadable sources are skipped, except for a sourceError, which is
+// returned. The walk stops at ceiling directories and, if configured, at the
+// git repository root; directories inside node_modules are passed through.
+func findVersionInParents(dir string) (string, versionSource, error) {
+	cfg := getConfig()
 	for {
-		// Check .nvurc first (nvu-specific)
-		version, err := readVersionFile(filepath.Join(dir, ".nvurc"))
-		if err == nil && version != "" {
-			return version
+		// a new version file in this directory changes its mtime
+		recordDep(dir)
+
+		// Check each version file in configured order (.nvurc, .nvmrc, .node-version, .tool-versions by default)
+		if cfg.IncludeNodeModules || !isInsideNodeModules(dir) {
+			for _, name := range cfg.VersionFiles {
+				version, source, err := readVersionSource(dir, name)
+				if isSourceError(err) {
+					return "", source, err
+				}
+				if err == nil && version != "" {
+					return version, source, nil
+				}
+			}
 		}
 
-		// Check .nvmrc (ecosystem compatible)
-		version, err = readVersionFile(filepath.Join(dir, ".nvmrc"))
-		if err == nil && version != "" {
-			return version
+		if cfg.StopAtGitRoot && isRepositoryRoot(dir) {
+			break
 		}
 
 		// Move to parent directory
 		parent := filepath.Dir(dir)
-		if parent == dir {
-			// Reached root
+		if parent == dir || isCeilingDirectory(parent) {
+			// Reached root or a ceiling
 			break
 		}
 		dir = parent
 	}
-	return ""
+	return "", versionSource{}, nil
+}
+
+// readVersionSource reads the version set by one entry of the version files
+// list in dir. Entries are file names, or "<file>#<field>" for a field of a
+// JSON file such as "package.json#engines.node".
+func readVersionSource(dir string, name string) (string, versionSource, error) {
+	file, field, ok := strings.Cut(name, "#")
+	if !ok {
+		path := filepath.Join(dir, name)
+		if name == toolVersionsFile {
+			version, line, err := readToolVersionsFile(path)
+			return version, versionSource{path: path, line: line}, err
+		}
+		if name == projectSettingsFile {
+			return readProjectVersion(path)
+		}
+		version, line, err := readVersionFileLine(path)
+		return version, versionSource{path: path, line: line}, err
+	}
+
+	path := filepath.Join(dir, file)
+	switch field {
+	case voltaNodeField:
+		// Volta pins may be inherited through "extends"
+		return readVoltaNode(path)
+	case devEnginesRuntimeField:
+		// devEngines.runtime lists runtimes with their own onFail behaviour
+		return readDevEnginesRuntime(path)
+	}
+	version, err := readPackageJSONField(path, field)
+	return version, versionSource{path: path, field: field}, err
 }
 
-// readVersionFile reads a version from a file, trimming whitespace
+// readVersionFile reads a version from a file, skipping comments and blank lines
 func readVersionFile(path string) (string, error) {
-	content, err := os.ReadFile(path)
-	if err != nil {
-		return "", err
-	}
-	version := strings.TrimSpace(string(content))
-	return version, nil
+	version, _, err := readVersionFileLine(path)
+	return version, err
 }
 
 // findBinary locates the actual binary for the given command and version
@@ -219,8 +401,49 @@ func findBinary(name string, version string) (string, error) {
 	return binaryPath, nil
 }
 
-// resolveInstalledVersion finds the best matching installed version
+// resolveInstalledVersion finds the best matching installed version. Results
+// are memoized per process, and seeded from the resolution cache.
 func resolveInstalledVersion(versionsDir string, version string) (string, error) {
+	if resolved, ok := installedVersionMemo[version]; ok {
+		return resolved, nil
+	}
+
+	// installing or removing a version changes the directory's mtime
+	recordDep(versionsDir)
+	resolved, err := findInstalledVersion(versionsDir, version)
+	if err == nil {
+		installedVersionMemo[version] = resolved
+	}
+	return resolved, err
+}
+
+// findInstalledVersion scans versionsDir for the best match of a version expression
+func findInstalledVersion(versionsDir string, version string) (string, error) {
+	// user aliases (work, legacy, default) name another version expression
+	version, err := expandAlias(version)
+	if err != nil {
+		return "", err
+	}
+
+	// LTS aliases (lts/*, lts/iron, lts/-1) resolve through the cached release index
+	if isLTSAlias(version) {
+		return resolveLTSAlias(versionsDir, version)
+	}
+
+	// "node", "latest" and friends mean the newest installed release
+	if isLatestAlias(version) {
+		installed, err := listInstalledVersions(versionsDir)
+		if err != nil {
+			return "", fmt.Errorf("failed to read versions directory: %w", err)
+		}
+		for i := len(installed) - 1; i >= 0; i-- {
+			if !installed[i].version.isPrerelease() {
+				return installed[i].name, nil
+			}
+		}
+		return "", fmt.Errorf("no installed version matching %s", version)
+	}
+
 	// Normalize version - remove 'v' prefix for comparison
 	normalizedVersion := strings.TrimPrefix(version, "v")
 
@@ -234,30 +457,30 @@ func resolveInstalledVersion(versionsDir string, version string) (string, error)
 	}
 
 	// If no exact match, scan for partial version match (e.g., "20" matches "v20.19.6")
-	entries, err := os.ReadDir(versionsDir)
+	// or, failing that, an npm-style range (e.g., ">=20", "^20", "18.x")
+	var matchesVersion func(semver) bool
+	if partial, ok := parsePartialVersion(version); ok {
+		matchesVersion = partial.matches
+	} else if versionRange, ok := parseRange(version); ok {
+		matchesVersion = versionRange.satisfiedBy
+	} else {
+		return "", fmt.Errorf("no installed version matching %s", version)
+	}
+
+	installed, err := listInstalledVersions(versionsDir)
 	if err != nil {
 		return "", fmt.Errorf("failed to read versions directory: %w", err)
 	}
 
-	var bestMatch string
-	for _, entry := range entries {
-		if !entry.IsDir() {
-			continue
-		}
-		dirName := entry.Name()
-		dirVersion := strings.TrimPrefix(dirName, "v")
-
-		// Check if this version starts with our target
-		if strings.HasPrefix(dirVersion, normalizedVersion+".") || dirVersion == normalizedVersion {
-			// Prefer higher versions (simple string comparison works for semver)
-			if bestMatch == "" || dirName > bestMatch {
-				bestMatch = dirName
-			}
+	var matches []installedVersion
+	for _, iv := range installed {
+		if matchesVersion(iv.version) {
+			matches = append(matches, iv)
 		}
 	}
 
-	if bestMatch != "" {
-		return bestMatch, nil
+	if len(matches) > 0 {
+		return selectVersion(versionsDir, matches, getConfig().Select), nil
 	}
 
 	return "", fmt.Errorf("no installed version matching %s", version)
@@ -265,37 +488,7 @@ func resolveInstalledVersion(versionsDir string, version string) (string, error)
 
 // execBinary replaces the current process with the target binary
 func execBinary(binaryPath string, args []string) error {
-	// On Unix, use syscall.Exec to replace the process
-	// On Windows, we need to use exec.Command and wait
-	if runtime.GOOS == "windows" {
-		return execWindows(binaryPath, args)
-	}
-	return execUnix(binaryPath, args)
-}
-
-func execUnix(binaryPath string, args []string) error {
-	// Replace args[0] with the actual binary path
-	args[0] = binaryPath
-	return syscall.Exec(binaryPath, args, os.Environ())
-}
-
-func execWindows(binaryPath string, args []string) error {
-	// On Windows, we can't use syscall.Exec, so we spawn and wait
-	cmd := exec.Command(binaryPath, args[1:]...)
-	cmd.Stdin = os.Stdin
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	cmd.Env = os.Environ()
-
-	err := cmd.Run()
-	if err != nil {
-		if exitError, ok := err.(*exec.ExitError); ok {
-			os.Exit(exitError.ExitCode())
-		}
-		return err
-	}
-	os.Exit(0)
-	return nil
+	return execBinaryWithEnv(binaryPath, args, nil)
 }
 
 // isGlobalInstall checks if the current npm command is a global install
@@ -328,13 +521,33 @@ func isGlobalUninstall() bool {
 	return hasGlobal && hasUninstall
 }
 
-// routeToDefaultBinary routes a binary name to the default Node version's bin directory
-// or to system binary if default is "system" or empty
-func routeToDefaultBinary(name string) (string, error) {
-	nvuHome, err := getNvuHome()
+// routeToDefaultBinary routes a binary name that the resolved version doesn't
+// have to the default Node version's bin directory or to system binary if
+// default is "system" or empty. Either is a fallback (see reportFallback).
+func routeToDefaultBinary(name string, version string) (string, error) {
+	fallback, err := findDefaultBinary(name, version)
 	if err != nil {
 		return "", err
 	}
+	reportFallback(fallback.code, fallback.message)
+	return fallback.path, nil
+}
+
+// defaultBinary is the binary routeToDefaultBinary falls back to, with the
+// strict mode exit code and the message that describe the fallback
+type defaultBinary struct {
+	path    string
+	code    int
+	message string
+}
+
+// findDefaultBinary finds the binary routeToDefaultBinary would fall back to
+// without reporting the fallback, so nvu which can show it
+func findDefaultBinary(name string, version string) (defaultBinary, error) {
+	nvuHome, err := getNvuHome()
+	if err != nil {
+		return defaultBinary{}, err
+	}
 
 	defaultPath := filepath.Join(nvuHome, "default")
 	defaultVersion, _ := readVersionFile(defaultPath)
@@ -343,16 +556,17 @@ func routeToDefaultBinary(name string) (string, error) {
 	if defaultVersion == "" || defaultVersion == "system" {
 		systemPath := resolveSystemBinary(name)
 		if systemPath != "" {
-			return systemPath, nil
+			message := fmt.Sprintf("%s is not installed for Node %s, using system %s (%s)", name, version, name, systemPath)
+			return defaultBinary{path: systemPath, code: exitSystemFallback, message: message}, nil
 		}
-		return "", fmt.Errorf("system binary not found: %s", name)
+		return defaultBinary{}, fmt.Errorf("system binary not found: %s", name)
 	}
 
 	// Otherwise, use default version's bin directory
 	versionsDir := filepath.Join(nvuHome, "installed")
 	resolvedVersion, err := resolveInstalledVersion(versionsDir, defaultVersion)
 	if err != nil {
-		return "", err
+		return defaultBinary{}, err
 	}
 
 	// Look for the binary
@@ -370,14 +584,16 @@ func routeToDefaultBinary(name string) (string, error) {
 	}
 
 	if _, err := os.Stat(binaryPath); os.IsNotExist(err) {
-		return "", fmt.Errorf("binary not found: %s", name)
+		return defaultBinary{}, fmt.Errorf("binary not found: %s", name)
 	}
 
-	return binaryPath, nil
+	message := fmt.Sprintf("%s is not installed for Node %s, using the one from default Node %s", name, version, resolvedVersion)
+	return defaultBinary{path: binaryPath, code: exitDefaultFallback, message: message}, nil
 }
 
-// runNpmAndCreateShims runs npm and then creates shims for any new global binaries
-func runNpmAndCreateShims(npmPath string, args []string) {
+// runNpmAndCreateShims runs npm with the environment overrides env and then
+// creates shims for any new global binaries. It returns npm's exit code.
+func runNpmAndCreateShims(npmPath string, args []string, env map[string]string) int {
 	nvuHome, err := getNvuHome()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "nvu error: %s\n", err)
@@ -394,10 +610,8 @@ func runNpmAndCreateShims(npmPath string, args []string) {
 		// For system default, use system npm's prefix to find binaries
 		systemNpmPath := resolveSystemBinary("npm")
 		if systemNpmPath != "" {
-			cmd := exec.Command(systemNpmPath, "prefix", "-g")
-			output, err := cmd.Output()
+			prefix, err := runHelperNpm(systemNpmPath, "prefix", "-g")
 			if err == nil {
-				prefix := strings.TrimSpace(string(output))
 				if runtime.GOOS == "windows" {
 					nodeBinDir = prefix
 				} else {
@@ -443,37 +657,30 @@ func runNpmAndCreateShims(npmPath string, args []string) {
 	cmd.Stdin = os.Stdin
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
+	npmEnv := guardShimLoop(npmPath, env)
 	// Set npm_config_prefix to redirect symlinks to the default version's directory
 	if npmPrefix != "" {
-		cmd.Env = append(os.Environ(), "npm_config_prefix="+npmPrefix)
-	} else {
-		cmd.Env = os.Environ()
+		npmEnv["npm_config_prefix"] = npmPrefix
 	}
+	cmd.Env = buildEnv(npmEnv)
 
-	err = cmd.Run()
-	exitCode := 0
+	exitCode, err := runSupervised(cmd)
 	if err != nil {
-		if exitError, ok := err.(*exec.ExitError); ok {
-			exitCode = exitError.ExitCode()
-		} else {
-			fmt.Fprintf(os.Stderr, "nvu error: failed to run npm: %s\n", err)
-			os.Exit(1)
-		}
+		fmt.Fprintf(os.Stderr, "nvu error: failed to run npm: %s\n", err)
+		os.Exit(1)
 	}
 
-	// If npm failed, don't create shims
-	if exitCode != 0 {
-		os.Exit(exitCode)
-	}
+	// Reconcile shims even if npm failed or was interrupted: it may have
+	// changed the bin directory before it stopped
 
 	// Get list of binaries after npm install
 	if nodeBinDir == "" {
-		os.Exit(0)
+		return exitCode
 	}
 
 	entries, err := os.ReadDir(nodeBinDir)
 	if err != nil {
-		os.Exit(0)
+		return exitCode
 	}
 
 	// Find new binaries and create shims
@@ -526,7 +733,7 @@ func runNpmAndCreateShims(npmPath string, args []string) {
 
 	}
 
-	os.Exit(0)
+	return exitCode
 }
 
 // getBaseName returns the filename without extension
@@ -547,8 +754,9 @@ func copyFile(src, dst string) error {
 	return os.WriteFile(dst, data, 0755)
 }
 
-// runNpmAndRemoveShims runs npm uninstall and then removes shims for removed binaries
-func runNpmAndRemoveShims(npmPath string, args []string) {
+// runNpmAndRemoveShims runs npm uninstall with the environment overrides env
+// and then removes shims for removed binaries. It returns npm's exit code.
+func runNpmAndRemoveShims(npmPath string, args []string, env map[string]string) int {
 	nvuHome, err := getNvuHome()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "nvu error: %s\n", err)
@@ -565,10 +773,8 @@ func runNpmAndRemoveShims(npmPath string, args []string) {
 		// For system default, use system npm's prefix to find binaries
 		systemNpmPath := resolveSystemBinary("npm")
 		if systemNpmPath != "" {
-			cmd := exec.Command(systemNpmPath, "prefix", "-g")
-			output, err := cmd.Output()
+			prefix, err := runHelperNpm(systemNpmPath, "prefix", "-g")
 			if err == nil {
-				prefix := strings.TrimSpace(string(output))
 				if runtime.GOOS == "windows" {
 					nodeBinDir = prefix
 				} else {
@@ -614,32 +820,25 @@ func runNpmAndRemoveShims(npmPath string, args []string) {
 	cmd.Stdin = os.Stdin
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
+	npmEnv := guardShimLoop(npmPath, env)
 	// Set npm_config_prefix to redirect symlinks to the default version's directory
 	if npmPrefix != "" {
-		cmd.Env = append(os.Environ(), "npm_config_prefix="+npmPrefix)
-	} else {
-		cmd.Env = os.Environ()
+		npmEnv["npm_config_prefix"] = npmPrefix
 	}
+	cmd.Env = buildEnv(npmEnv)
 
-	err = cmd.Run()
-	exitCode := 0
+	exitCode, err := runSupervised(cmd)
 	if err != nil {
-		if exitError, ok := err.(*exec.ExitError); ok {
-			exitCode = exitError.ExitCode()
-		} else {
-			fmt.Fprintf(os.Stderr, "nvu error: failed to run npm: %s\n", err)
-			os.Exit(1)
-		}
+		fmt.Fprintf(os.Stderr, "nvu error: failed to run npm: %s\n", err)
+		os.Exit(1)
 	}
 
-	// If npm failed, don't remove shims
-	if exitCode != 0 {
-		os.Exit(exitCode)
-	}
+	// Reconcile shims even if npm failed or was interrupted: it may have
+	// changed the bin directory before it stopped
 
 	// Get list of binaries after npm uninstall
 	if nodeBinDir == "" {
-		os.Exit(0)
+		return exitCode
 	}
 
 	binariesAfter := make(map[string]bool)
@@ -673,7 +872,7 @@ func runNpmAndRemoveShims(npmPath string, args []string) {
 		}
 	}
 
-	os.Exit(0)
+	return exitCode
 }
 
 // resolveSystemBinary looks for a system-installed binary in PATH (not the nvu binary)
@@ -710,9 +909,10 @@ func resolveSystemBinary(name string) string {
 			continue
 		}
 
-		// Make sure it's not our binary (resolve symlinks)
+		// Make sure it's not our binary (resolve symlinks), or a copy of it
+		// from another nvu home, which would hand the command back to us
 		realPath, _ := filepath.EvalSymlinks(candidate)
-		if pathsEqual(realPath, selfPath) {
+		if pathsEqual(realPath, selfPath) || isShimBinary(realPath) {
 			continue
 		}
 
@@ -792,9 +992,33 @@ func getPathWithoutNvuBinWithPrepend(nodeDir string) string {
 	return nodeDir + string(os.PathListSeparator) + strings.Join(cleanDirs, string(os.PathListSeparator))
 }
 
+// runHelperNpm runs npm for the shim's own use, such as `npm root -g`, and
+// returns its output. The shims are taken off PATH and auto-install is off,
+// so an npm that starts with `#!/usr/bin/env node` can't come back into a
+// shim that would wait on this one. npm's errors go to stderr.
+func runHelperNpm(npmPath string, args ...string) (string, error) {
+	cmd := exec.Command(npmPath, args...)
+	cmd.Stderr = os.Stderr
+	cmd.Env = buildEnv(map[string]string{"PATH": getPathWithoutNvuBin(), "NVU_AUTO_INSTALL": "0"})
+	output, err := cmd.Output()
+	return strings.TrimSpace(string(output)), err
+}
+
 // execBinaryWithEnv replaces the current process with the target binary, with custom env vars
 func execBinaryWithEnv(binaryPath string, args []string, envOverrides map[string]string) error {
-	// Build environment with overrides
+	env := buildEnv(guardShimLoop(binaryPath, envOverrides))
+
+	// On Unix, use syscall.Exec to replace the process
+	// On Windows, we need to use exec.Command and wait
+	if runtime.GOOS == "windows" {
+		return execWindowsWithEnv(binaryPath, args, env)
+	}
+	return execUnixWithEnv(binaryPath, args, env)
+}
+
+// buildEnv returns the current environment with overrides applied. An empty
+// override removes the variable.
+func buildEnv(envOverrides map[string]string) []string {
 	env := os.Environ()
 	for key, value := range envOverrides {
 		// Remove existing key if present
@@ -806,14 +1030,12 @@ func execBinaryWithEnv(binaryPath string, args []string, envOverrides map[string
 			}
 		}
 		// Add new value
-		newEnv = append(newEnv, key+"="+value)
+		if value != "" {
+			newEnv = append(newEnv, key+"="+value)
+		}
 		env = newEnv
 	}
-
-	if runtime.GOOS == "windows" {
-		return execWindowsWithEnv(binaryPath, args, env)
-	}
-	return execUnixWithEnv(binaryPath, args, env)
+	return env
 }
 
 func execUnixWithEnv(binaryPath string, args []string, env []string) error {
@@ -828,20 +1050,17 @@ func execWindowsWithEnv(binaryPath string, args []string, env []string) error {
 	cmd.Stderr = os.Stderr
 	cmd.Env = env
 
-	err := cmd.Run()
+	code, err := runSupervised(cmd)
 	if err != nil {
-		if exitError, ok := err.(*exec.ExitError); ok {
-			os.Exit(exitError.ExitCode())
-		}
 		return err
 	}
-	os.Exit(0)
+	os.Exit(code)
 	return nil
 }
 
 // isConcreteVersion reports whether a version expression is a single plain
 // version like "22" or "v20.19.6", as opposed to a list ("22,20,18"), a range
-// (">=18", "18.x") or an alias ("engines", "lts") that only the CLI can resolve
+// (">=18", "18.x") or an alias ("engines", "lts")
 func isConcreteVersion(version string) bool {
 	version = strings.TrimPrefix(version, "v")
 	if version == "" {
@@ -860,13 +1079,43 @@ func isConcreteVersion(version string) bool {
 	return true
 }
 
+// isResolvableVersion reports whether this binary can resolve a version
+// expression against the installed versions on its own: a concrete version or
+// an npm-style range or an LTS alias. Lists are narrowed to one entry by
+// selectFromList first; "engines" is left to the CLI.
+func isResolvableVersion(version string) bool {
+	if strings.TrimSpace(version) == "" {
+		return false
+	}
+	if isConcreteVersion(version) || isLTSAlias(version) {
+		return true
+	}
+	_, ok := parseRange(version)
+	return ok
+}
+
 // runDirect handles 'nvu <version> <command> [args...]' without the CLI for the
-// cases this binary can already resolve on its own: "system", or a single
-// concrete version that is already installed. Everything else - subcommands,
-// flags, version lists, ranges, "engines", uninstalled versions - falls through
-// to the CLI. Returns false when the invocation is not eligible; on success the
-// process is replaced and this never returns.
+// cases this binary can already resolve on its own: "system", or a concrete
+// version, range, LTS alias, user alias or preference list with an installed
+// match, plus 'nvu which' and 'nvu alias', which must answer exactly as the
+// shim resolves. Everything else - other subcommands, flags, "engines",
+// uninstalled versions - falls through to the CLI. Returns false when the invocation is not eligible; on
+// success the process is replaced (or has exited) and this never returns.
 func runDirect() bool {
+	if len(os.Args) >= 2 {
+		switch os.Args[1] {
+		case "which":
+			runWhich(os.Args[2:])
+			return true
+		case "alias":
+			runAlias(os.Args[2:])
+			return true
+		case "unalias":
+			runUnalias(os.Args[2:])
+			return true
+		}
+	}
+
 	// need at least a version expression and a command
 	if len(os.Args) < 3 {
 		return false
@@ -876,6 +1125,12 @@ func runDirect() bool {
 	if strings.HasPrefix(version, "-") {
 		return false // an option, not a version expression
 	}
+	version, err := expandAlias(version)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "nvu error: %s\n", err)
+		os.Exit(1)
+	}
+	version = selectFromList(version)
 
 	command := os.Args[2]
 	commandArgs := os.Args[3:]
@@ -898,7 +1153,7 @@ func runDirect() bool {
 		}
 		nodeBinDir = filepath.Dir(nodePath)
 	} else {
-		if !isConcreteVersion(version) {
+		if !isResolvableVersion(version) {
 			return false
 		}
 
@@ -919,6 +1174,13 @@ func runDirect() bool {
 	// put the resolved version's bin first and drop the shims so children of
 	// the command resolve to real binaries for that version
 	env := map[string]string{"PATH": getPathWithoutNvuBinWithPrepend(nodeBinDir)}
+	if version != "system" {
+		for key, value := range getPinEnv(nodeBinDir) {
+			if key != "PATH" {
+				env[key] = value
+			}
+		}
+	}
 
 	args := append([]string{binaryPath}, commandArgs...)
 	if err := execBinaryWithEnv(binaryPath, args, env); err != nil {
@@ -938,7 +1200,15 @@ func runNvuCli() {
 	}
 
 	// Resolve the Node version to use (same logic as normal commands)
-	version, err := resolveVersion()
+	version, source, err := resolveVersionSource()
+	if isDisabledVersion(version, source) {
+		runPassthrough("nvu", source.String())
+	}
+	if isSourceError(err) {
+		// the project's version can't be used, but the CLI still has to run
+		// (e.g. to install it), so use the global default
+		version, _, err = readDefaultVersion()
+	}
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "nvu error: %s\n", err)
 		fmt.Fprintf(os.Stderr, "\nTo fix this, either:\n")
@@ -949,74 +1219,12 @@ func runNvuCli() {
 	}
 
 	// Find node binary and nvu script based on version
-	var nodePath string
-	var nvuScript string
-
-	if version == "system" {
-		// Use system node and find nvu in system npm's global modules
-		nodePath = resolveSystemBinary("node")
-		if nodePath == "" {
-			fmt.Fprintf(os.Stderr, "nvu error: system node not found\n")
-			os.Exit(1)
-		}
-
-		// Find system npm and use `npm root -g` to locate global modules
-		npmPath := resolveSystemBinary("npm")
-		if npmPath == "" {
-			fmt.Fprintf(os.Stderr, "nvu error: system npm not found\n")
-			os.Exit(1)
-		}
-
-		cmd := exec.Command(npmPath, "root", "-g")
-		output, err := cmd.Output()
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "nvu error: failed to determine npm global location\n")
-			os.Exit(1)
-		}
-
-		globalRoot := strings.TrimSpace(string(output))
-		nvuScript = filepath.Join(globalRoot, "node-version-use", "bin", "cli.js")
-
-		if _, err := os.Stat(nvuScript); os.IsNotExist(err) {
-			fmt.Fprintf(os.Stderr, "nvu error: node-version-use not installed in system Node\n")
-			fmt.Fprintf(os.Stderr, "\nTo fix: npm install -g node-version-use\n")
-			os.Exit(1)
-		}
-	} else {
-		// Find the node binary for the resolved version
-		nodePath, err = findBinary("node", version)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "nvu error: %s\n", err)
-			fmt.Fprintf(os.Stderr, "\nNode %s may not be installed. Run: nvu install %s\n", version, version)
-			os.Exit(1)
-		}
-
-		// Find the nvu CLI script in the resolved version's global modules
-		nvuHome, err := getNvuHome()
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "nvu error: failed to get nvu home directory: %s\n", err)
-			os.Exit(1)
-		}
-
-		versionsDir := filepath.Join(nvuHome, "installed")
-		resolvedVersion, err := resolveInstalledVersion(versionsDir, version)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "nvu error: failed to resolve version %s: %s\n", version, err)
-			os.Exit(1)
-		}
-
-		if runtime.GOOS == "windows" {
-			nvuScript = filepath.Join(versionsDir, resolvedVersion, "node_modules", "node-version-use", "bin", "cli.js")
-		} else {
-			nvuScript = filepath.Join(versionsDir, resolvedVersion, "lib", "node_modules", "node-version-use", "bin", "cli.js")
-		}
-
-		if _, err := os.Stat(nvuScript); os.IsNotExist(err) {
-			fmt.Fprintf(os.Stderr, "nvu error: node-version-use not installed in Node %s\n", resolvedVersion)
-			fmt.Fprintf(os.Stderr, "\nTo fix: npm install -g node-version-use\n")
-			os.Exit(1)
-		}
+	nodePath, nvuScript, err := findNvuCli(version)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "nvu error: %s\n", err)
+		os.Exit(1)
 	}
+	checkNotShim(nodePath)
 
 	// Prepend node's bin directory to PATH so shebang finds the real node, not the nvu shim
 	nodeDir := filepath.Dir(nodePath)
@@ -1057,15 +1265,12 @@ func runNvuCli() {
 		cmd.Stdin = os.Stdin
 		cmd.Stdout = os.Stdout
 		cmd.Stderr = os.Stderr
-		err := cmd.Run()
+		code, err := runSupervised(cmd)
 		if err != nil {
-			if exitError, ok := err.(*exec.ExitError); ok {
-				os.Exit(exitError.ExitCode())
-			}
 			fmt.Fprintf(os.Stderr, "nvu error: failed to exec: %s\n", err)
 			os.Exit(1)
 		}
-		os.Exit(0)
+		os.Exit(code)
 	} else {
 		// Unix: replace process with syscall.Exec to preserve TTY
 		err = syscall.Exec(nvuScript, args, env)
@@ -1076,3 +1281,62 @@ func runNvuCli() {
 	}
 }
 
+// findNvuCli locates the node binary and the nvu CLI script to run it with
+// for a version: system node with the CLI in its global modules, or the CLI
+// installed in that version's own global modules. Errors include the fix.
+func findNvuCli(version string) (nodePath string, nvuScript string, err error) {
+	if version == "system" {
+		// Use system node and find nvu in system npm's global modules
+		nodePath = resolveSystemBinary("node")
+		if nodePath == "" {
+			return "", "", fmt.Errorf("system node not found")
+		}
+
+		// Find system npm and use `npm root -g` to locate global modules
+		npmPath := resolveSystemBinary("npm")
+		if npmPath == "" {
+			return "", "", fmt.Errorf("system npm not found")
+		}
+
+		globalRoot, err := runHelperNpm(npmPath, "root", "-g")
+		if err != nil {
+			return "", "", fmt.Errorf("failed to determine npm global location")
+		}
+
+		nvuScript = filepath.Join(globalRoot, "node-version-use", "bin", "cli.js")
+
+		if _, err := os.Stat(nvuScript); os.IsNotExist(err) {
+			return "", "", fmt.Errorf("node-version-use not installed in system Node\n\nTo fix: npm install -g node-version-use")
+		}
+	} else {
+		// Find the node binary for the resolved version
+		nodePath, err = findBinary("node", version)
+		if err != nil {
+			return "", "", fmt.Errorf("%s\n\nNode %s may not be installed. Run: nvu install %s", err, version, version)
+		}
+
+		// Find the nvu CLI script in the resolved version's global modules
+		nvuHome, err := getNvuHome()
+		if err != nil {
+			return "", "", fmt.Errorf("failed to get nvu home directory: %s", err)
+		}
+
+		versionsDir := filepath.Join(nvuHome, "installed")
+		resolvedVersion, err := resolveInstalledVersion(versionsDir, version)
+		if err != nil {
+			return "", "", fmt.Errorf("failed to resolve version %s: %s", version, err)
+		}
+
+		if runtime.GOOS == "windows" {
+			nvuScript = filepath.Join(versionsDir, resolvedVersion, "node_modules", "node-version-use", "bin", "cli.js")
+		} else {
+			nvuScript = filepath.Join(versionsDir, resolvedVersion, "lib", "node_modules", "node-version-use", "bin", "cli.js")
+		}
+
+		if _, err := os.Stat(nvuScript); os.IsNotExist(err) {
+			return "", "", fmt.Errorf("node-version-use not installed in Node %s\n\nTo fix: npm install -g node-version-use", resolvedVersion)
+		}
+	}
+	return nodePath, nvuScript, nil
+}
+
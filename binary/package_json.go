@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// enginesNodeSource is the version files entry for package.json engines.node
+const enginesNodeSource = "package.json#engines.node"
+
+// voltaNodeField is the field Volta pins the Node version in
+const voltaNodeField = "volta.node"
+
+// voltaNodeSource is the version files entry for package.json volta.node
+const voltaNodeSource = "package.json#" + voltaNodeField
+
+// devEnginesRuntimeField is where npm declares the development runtime
+const devEnginesRuntimeField = "devEngines.runtime"
+
+// devEnginesRuntimeSource is the version files entry for package.json devEngines.runtime
+const devEnginesRuntimeSource = "package.json#" + devEnginesRuntimeField
+
+// readPackageJSON parses a package.json (or any JSON file) into generic values
+func readPackageJSON(path string) (map[string]interface{}, error) {
+	content, err := readSourceFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var pkg map[string]interface{}
+	if err := json.Unmarshal(content, &pkg); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", path, err)
+	}
+	return pkg, nil
+}
+
+// lookupField returns the value at a dotted path like "engines.node", or nil
+func lookupField(value interface{}, field string) interface{} {
+	for _, key := range strings.Split(field, ".") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = object[key]
+	}
+	return value
+}
+
+// readPackageJSONField reads a version from a dotted field of a JSON file,
+// e.g. "engines.node" in package.json. A missing field yields "".
+func readPackageJSONField(path string, field string) (string, error) {
+	pkg, err := readPackageJSON(path)
+	if err != nil {
+		return "", err
+	}
+
+	version, _ := lookupField(pkg, field).(string)
+	return strings.TrimSpace(version), nil
+}
+
+// readVoltaNode reads the Volta pin from a package.json. A package without
+// its own volta.node inherits the pin of the file named by volta.extends
+// (relative to the package), as Volta does. The returned source is the file
+// the pin was actually found in.
+func readVoltaNode(path string) (string, versionSource, error) {
+	visited := make(map[string]bool)
+	for {
+		if absPath, err := filepath.Abs(path); err == nil {
+			path = absPath
+		}
+		if visited[path] {
+			return "", versionSource{}, fmt.Errorf("volta.extends cycle at %s", path)
+		}
+		visited[path] = true
+
+		pkg, err := readPackageJSON(path)
+		if err != nil {
+			return "", versionSource{}, err
+		}
+
+		if version, _ := lookupField(pkg, voltaNodeField).(string); strings.TrimSpace(version) != "" {
+			return strings.TrimSpace(version), versionSource{path: path, field: voltaNodeField}, nil
+		}
+
+		extends, _ := lookupField(pkg, "volta.extends").(string)
+		if extends == "" {
+			return "", versionSource{}, nil
+		}
+		if !filepath.IsAbs(extends) {
+			extends = filepath.Join(filepath.Dir(path), extends)
+		}
+		path = extends
+	}
+}
+
+// readDevEnginesRuntime reads the Node constraint from npm's
+// devEngines.runtime, which is either one runtime object like
+// {"name": "node", "version": "^22", "onFail": "error"} or an array of them.
+// When no installed version satisfies the constraint, the fallback policy and
+// auto-install get the first chance to provide one. Only if neither does,
+// onFail decides: "ignore" and "warn" skip the entry so resolution continues
+// (warn says so on stderr), while "error" (npm's default) stops resolution
+// with a sourceError instead of falling back to the global default. Only the
+// first node entry of an array is read.
+func readDevEnginesRuntime(path string) (string, versionSource, error) {
+	source := versionSource{path: path, field: devEnginesRuntimeField}
+
+	pkg, err := readPackageJSON(path)
+	if err != nil {
+		return "", source, err
+	}
+
+	var runtimes []interface{}
+	switch value := lookupField(pkg, devEnginesRuntimeField).(type) {
+	case []interface{}:
+		runtimes = value
+	case map[string]interface{}:
+		runtimes = []interface{}{value}
+	}
+
+	for _, runtime := range runtimes {
+		entry, ok := runtime.(map[string]interface{})
+		if !ok || entry["name"] != "node" {
+			continue
+		}
+		version, _ := entry["version"].(string)
+		version = strings.TrimSpace(version)
+		if version == "" {
+			return "", source, nil
+		}
+
+		if isVersionInstalled(version) {
+			return version, source, nil
+		}
+		if provided := provideMissingVersion(version, source); provided != "" {
+			return provided, source, nil
+		}
+
+		onFail, _ := entry["onFail"].(string)
+		switch onFail {
+		case "ignore":
+			return "", source, nil
+		case "warn":
+			markUncacheable()
+			fmt.Fprintf(os.Stderr, "nvu warning: %s requires node %s, but no installed version satisfies it\n", source, version)
+			return "", source, nil
+		default:
+			return "", source, &sourceError{
+				source: source,
+				err:    fmt.Errorf("requires node %s, but no installed version satisfies it. Run: nvu install %s", version, version),
+			}
+		}
+	}
+	return "", source, nil
+}
+
+// provideMissingVersion returns the version to use for one that isn't
+// installed: the fallback policy's substitute, or the version itself once
+// auto-install has installed it. It returns "" if neither helped.
+func provideMissingVersion(version string, source versionSource) string {
+	if substitute := applyFallbackPolicy(version, source); substitute != version {
+		return substitute
+	}
+	if installMissingVersions && autoInstall(version, source) {
+		return version
+	}
+	return ""
+}
+
+// isVersionInstalled reports whether a version expression resolves to an
+// installed version ("system" always counts as installed)
+func isVersionInstalled(version string) bool {
+	if version == "system" {
+		return true
+	}
+	nvuHome, err := getNvuHome()
+	if err != nil {
+		return false
+	}
+	_, err = resolveInstalledVersion(filepath.Join(nvuHome, "installed"), version)
+	return err == nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Pinning keeps one Node version for a whole process tree. The shim exports
+// the install it resolved and puts that version's bin directory first on
+// PATH before it execs, so children run the same version by name, and a
+// nested shim (reached by absolute path, or for a tool the version lacks)
+// uses the pinned install instead of resolving again from its own cwd.
+// NVU_VERSION still wins over a pin. NVU_PIN=0 opts out: a shim that sees
+// it ignores an inherited pin, takes the pinned directory back off PATH and
+// doesn't pin, so everything below it resolves afresh.
+
+// Environment variables that carry a pin to child processes
+const (
+	resolvedVersionEnv = "NVU_RESOLVED_VERSION" // installed directory name, e.g. v20.19.6
+	resolvedDirEnv     = "NVU_RESOLVED_DIR"     // full path of the installed directory
+)
+
+// readPinnedVersion returns the version pinned by a parent shim, if it is an
+// install of this NVU_HOME that still exists
+func readPinnedVersion() (string, versionSource) {
+	if getConfig().NoPin {
+		return "", versionSource{}
+	}
+	version := os.Getenv(resolvedVersionEnv)
+	dir := os.Getenv(resolvedDirEnv)
+	if version == "" || dir == "" || filepath.Base(dir) != version {
+		return "", versionSource{}
+	}
+
+	nvuHome, err := getNvuHome()
+	if err != nil || !pathsEqual(filepath.Dir(dir), filepath.Join(nvuHome, "installed")) {
+		return "", versionSource{}
+	}
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		return "", versionSource{}
+	}
+	return version, versionSource{env: resolvedVersionEnv}
+}
+
+// getPinEnv returns the environment overrides that pin the install whose
+// node is in binDir for child processes. With pinning turned off it instead
+// clears an inherited pin, if there is one.
+func getPinEnv(binDir string) map[string]string {
+	if getConfig().NoPin {
+		inherited := os.Getenv(resolvedDirEnv)
+		if inherited == "" {
+			return nil
+		}
+		return map[string]string{
+			resolvedVersionEnv: "",
+			resolvedDirEnv:     "",
+			"PATH":             getPathWithout(getInstallBinDir(inherited)),
+		}
+	}
+
+	dir := binDir
+	if filepath.Base(dir) == "bin" {
+		dir = filepath.Dir(dir) // Unix layout; on Windows node.exe is at the root
+	}
+	path := getPathWithout(binDir)
+	if path == "" {
+		path = binDir
+	} else {
+		path = binDir + string(os.PathListSeparator) + path
+	}
+	return map[string]string{
+		resolvedVersionEnv: filepath.Base(dir),
+		resolvedDirEnv:     dir,
+		"PATH":             path,
+	}
+}
+
+// getInstallBinDir returns the directory holding node in an installed version
+func getInstallBinDir(dir string) string {
+	if _, err := os.Stat(filepath.Join(dir, "bin")); err == nil {
+		return filepath.Join(dir, "bin")
+	}
+	return dir
+}
+
+// getPathWithout returns PATH with every occurrence of dir removed
+func getPathWithout(dir string) string {
+	var dirs []string
+	for _, entry := range strings.Split(getPathEnv(), string(os.PathListSeparator)) {
+		if entry != "" && !pathsEqual(filepath.Clean(entry), filepath.Clean(dir)) {
+			dirs = append(dirs, entry)
+		}
+	}
+	return strings.Join(dirs, string(os.PathListSeparator))
+}
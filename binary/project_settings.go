@@ -0,0 +1,529 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// A project's .nvurc holds its version and settings. The plain form is the
+// version on a line of its own, optionally with `key = value` settings:
+//
+//	20.11.0
+//	fallback = same-minor
+//
+// The same file can be written as TOML, which also sets the environment of
+// every command run through the shims:
+//
+//	version = "20.11.0"
+//	pathPrepend = ["node_modules/.bin"]
+//	unset = ["NODE_ENV"]
+//
+//	[env]
+//	NODE_OPTIONS = "--max-old-space-size=8192"
+//
+// The TOML understood is the part these keys need: strings, arrays of
+// strings, tables, dotted and quoted keys, and inline tables of strings
+// (env = { NODE_ENV = "test" }). The file can also be JSON, detected by its
+// leading '{', with the same keys. Settings and
+// environment apply to commands run in the .nvurc's directory or below it,
+// wherever their version came from.
+
+// projectSettingsFile holds per-project settings
+const projectSettingsFile = ".nvurc"
+
+// projectFile is a parsed .nvurc
+type projectFile struct {
+	version     string            // the version, "" if the file doesn't set one
+	line        int               // line of the version in the text form
+	settings    map[string]string // other keys, e.g. fallback or pre-exec
+	env         map[string]string // variables to set
+	unset       []string          // variables to remove
+	pathPrepend []string          // directories to put first on PATH, relative to the file
+}
+
+// projectFileMemo holds the .nvurc files already read, by directory
+var projectFileMemo = make(map[string]*projectFile)
+
+// parseSettingLine splits a `key = value` line
+func parseSettingLine(line string) (string, string, bool) {
+	key, rest, ok := parseKey(line)
+	rest = strings.TrimSpace(rest)
+	if !ok || !strings.HasPrefix(rest, "=") {
+		return "", "", false
+	}
+	return key, strings.TrimSpace(rest[1:]), true
+}
+
+// parseKey reads a key from the start of s and returns it with the rest of
+// s. As in TOML, a key is made of bare or quoted parts joined by dots, e.g.
+// env."NODE_OPTIONS", and comes back with its parts joined by dots. Bare keys
+// start with a letter or '_', so ranges such as ">=18" are never mistaken
+// for settings.
+func parseKey(s string) (string, string, bool) {
+	var parts []string
+	for {
+		s = strings.TrimLeft(s, " \t")
+		if strings.HasPrefix(s, `"`) || strings.HasPrefix(s, "'") {
+			part, rest, err := parseQuotedString(s)
+			if err != nil || part == "" {
+				return "", "", false
+			}
+			parts, s = append(parts, part), rest
+		} else {
+			end := 0
+			for end < len(s) && isBareKeyChar(s[end], end == 0) {
+				end++
+			}
+			if end == 0 {
+				return "", "", false
+			}
+			parts, s = append(parts, s[:end]), s[end:]
+		}
+
+		s = strings.TrimLeft(s, " \t")
+		if !strings.HasPrefix(s, ".") {
+			return strings.Join(parts, "."), s, true
+		}
+		s = s[1:]
+	}
+}
+
+// isBareKeyChar reports whether char can appear in a bare key, or start one
+func isBareKeyChar(char byte, first bool) bool {
+	if char >= 'a' && char <= 'z' || char >= 'A' && char <= 'Z' || char == '_' {
+		return true
+	}
+	return !first && (char >= '0' && char <= '9' || char == '-')
+}
+
+// parseProjectFile parses a .nvurc in any of its forms
+func parseProjectFile(content string) (*projectFile, error) {
+	content = strings.TrimPrefix(content, utf8BOM)
+	project := &projectFile{settings: make(map[string]string), env: make(map[string]string)}
+	if strings.HasPrefix(strings.TrimSpace(content), "{") {
+		return project, project.parseJSON(content)
+	}
+	return project, project.parseText(content)
+}
+
+// parseJSON reads the JSON form
+func (p *projectFile) parseJSON(content string) error {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(content), &fields); err != nil {
+		return fmt.Errorf("invalid JSON: %s", err)
+	}
+	for key, raw := range fields {
+		var err error
+		switch key {
+		case "version":
+			if json.Unmarshal(raw, &p.version) != nil {
+				err = errors.New("must be a string")
+			}
+		case "env":
+			if json.Unmarshal(raw, &p.env) != nil {
+				err = errors.New("must be an object of strings")
+			}
+		case "pathPrepend", "unset":
+			var items []string
+			if json.Unmarshal(raw, &items) != nil {
+				var item string
+				if json.Unmarshal(raw, &item) != nil {
+					err = errors.New("must be a string or an array of strings")
+					break
+				}
+				items = []string{item}
+			}
+			p.add(key, items)
+		default:
+			var value string
+			if json.Unmarshal(raw, &value) == nil {
+				p.settings[key] = value
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("%s %s", key, err)
+		}
+	}
+	return nil
+}
+
+// parseText reads the plain and TOML forms. The first line that isn't a
+// setting, a table header or a comment is the version.
+func (p *projectFile) parseText(content string) error {
+	lines := strings.Split(content, "\n")
+	table := ""
+	for i := 0; i < len(lines); i++ {
+		lineNumber := i + 1
+		line := strings.TrimSpace(strings.TrimRight(lines[i], "\r"))
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		if line[0] == '[' {
+			header := stripComment(line)
+			name, rest, ok := parseKey(header[1:])
+			if !ok || strings.TrimSpace(rest) != "]" {
+				return fmt.Errorf("line %d: invalid table header %q", lineNumber, header)
+			}
+			table = name
+			continue
+		}
+
+		key, raw, ok := parseSettingLine(line)
+		if !ok {
+			if table != "" {
+				return fmt.Errorf("line %d: expected `key = value` in [%s]", lineNumber, table)
+			}
+			if p.version == "" {
+				p.version, p.line = stripComment(line), lineNumber
+			}
+			continue
+		}
+
+		if table != "" {
+			key = table + "." + key
+		}
+
+		// an inline table sets the keys under this one, as a table would
+		if strings.HasPrefix(raw, "{") {
+			entries, err := parseInlineTable(raw)
+			if err != nil {
+				return fmt.Errorf("line %d: %s", lineNumber, err)
+			}
+			for name, value := range entries {
+				if key == "env" {
+					p.env[name] = value
+				} else {
+					p.settings[key+"."+name] = value
+				}
+			}
+			continue
+		}
+
+		// arrays may continue over several lines, until a ']' outside the
+		// strings in them
+		if strings.HasPrefix(raw, "[") {
+			raw = stripValueComment(raw)
+			for indexOutsideStrings(raw, ']') < 0 && i+1 < len(lines) {
+				i++
+				raw += " " + stripValueComment(strings.TrimRight(lines[i], "\r"))
+			}
+		}
+		values, isList, err := parseSettingValue(raw)
+		if err != nil {
+			return fmt.Errorf("line %d: %s", lineNumber, err)
+		}
+
+		switch {
+		case key == "version" && p.version == "":
+			p.version, p.line = values[0], lineNumber
+		case key == "pathPrepend" || key == "unset":
+			p.add(key, values)
+		case key == "env":
+			return fmt.Errorf("line %d: env must be a table, as [env] or env = { ... }", lineNumber)
+		case strings.HasPrefix(key, "env."):
+			if isList {
+				return fmt.Errorf("line %d: %s must be a string", lineNumber, key)
+			}
+			p.env[strings.TrimPrefix(key, "env.")] = values[0]
+		case !isList:
+			p.settings[key] = values[0]
+		}
+	}
+	return nil
+}
+
+// add appends to one of the list keys
+func (p *projectFile) add(key string, items []string) {
+	if key == "pathPrepend" {
+		p.pathPrepend = append(p.pathPrepend, items...)
+	} else {
+		p.unset = append(p.unset, items...)
+	}
+}
+
+// parseSettingValue parses the value of a setting: a TOML string ("..." or
+// '...'), an array of strings, or, as in plain .nvurc files, a bare word. A
+// single value is returned as a one-item list.
+func parseSettingValue(raw string) ([]string, bool, error) {
+	if !strings.HasPrefix(raw, "[") {
+		if !strings.HasPrefix(raw, `"`) && !strings.HasPrefix(raw, "'") {
+			return []string{stripComment(raw)}, false, nil
+		}
+		value, rest, err := parseQuotedString(raw)
+		if err != nil {
+			return nil, false, err
+		}
+		if stripComment(rest) != "" {
+			return nil, false, fmt.Errorf("unexpected %q after value", stripComment(rest))
+		}
+		return []string{value}, false, nil
+	}
+
+	items := []string{}
+	rest := strings.TrimSpace(raw[1:])
+	for !strings.HasPrefix(rest, "]") {
+		if rest == "" {
+			return nil, false, errors.New("unterminated array")
+		}
+		item, after, err := parseQuotedString(rest)
+		if err != nil {
+			return nil, false, err
+		}
+		items = append(items, item)
+		rest = strings.TrimSpace(after)
+		if strings.HasPrefix(rest, ",") {
+			rest = strings.TrimSpace(rest[1:])
+		} else if !strings.HasPrefix(rest, "]") {
+			return nil, false, errors.New("expected ',' or ']' in array")
+		}
+	}
+	if stripComment(rest[1:]) != "" {
+		return nil, false, fmt.Errorf("unexpected %q after array", stripComment(rest[1:]))
+	}
+	return items, true, nil
+}
+
+// stripValueComment removes a trailing # comment from a line of a value,
+// leaving any '#' inside its strings
+func stripValueComment(line string) string {
+	if i := indexOutsideStrings(line, '#'); i >= 0 {
+		line = line[:i]
+	}
+	return strings.TrimSpace(line)
+}
+
+// indexOutsideStrings returns the index of the first char in s that isn't
+// inside a basic or literal string, or -1 if there is none
+func indexOutsideStrings(s string, char byte) int {
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		switch {
+		case quote == '"' && s[i] == '\\':
+			i++
+		case quote != 0:
+			if s[i] == quote {
+				quote = 0
+			}
+		case s[i] == '"' || s[i] == '\'':
+			quote = s[i]
+		case s[i] == char:
+			return i
+		}
+	}
+	return -1
+}
+
+// parseInlineTable parses an inline table of strings such as
+// { NODE_ENV = "test", "NODE_OPTIONS" = '--inspect' }
+func parseInlineTable(raw string) (map[string]string, error) {
+	entries := make(map[string]string)
+	rest := strings.TrimSpace(raw[1:])
+	for !strings.HasPrefix(rest, "}") {
+		if rest == "" {
+			return nil, errors.New("unterminated inline table")
+		}
+		key, after, ok := parseSettingLine(rest)
+		if !ok {
+			return nil, fmt.Errorf("expected `key = value` in inline table at %q", rest)
+		}
+		value, after, err := parseQuotedString(after)
+		if err != nil {
+			return nil, fmt.Errorf("%s must be a string: %s", key, err)
+		}
+		entries[key] = value
+		rest = strings.TrimSpace(after)
+		if strings.HasPrefix(rest, ",") {
+			rest = strings.TrimSpace(rest[1:])
+		} else if rest != "" && !strings.HasPrefix(rest, "}") {
+			return nil, errors.New("expected ',' or '}' in inline table")
+		}
+	}
+	if stripComment(rest[1:]) != "" {
+		return nil, fmt.Errorf("unexpected %q after inline table", stripComment(rest[1:]))
+	}
+	return entries, nil
+}
+
+// parseQuotedString reads a TOML basic ("...") or literal ('...') string
+// from the start of s and returns it with the rest of s
+func parseQuotedString(s string) (string, string, error) {
+	if strings.HasPrefix(s, "'") {
+		end := strings.IndexByte(s[1:], '\'')
+		if end < 0 {
+			return "", "", errors.New("unterminated string")
+		}
+		return s[1 : end+1], s[end+2:], nil
+	}
+	if !strings.HasPrefix(s, `"`) {
+		return "", "", fmt.Errorf("expected a quoted string at %q", s)
+	}
+
+	var value strings.Builder
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '"':
+			return value.String(), s[i+1:], nil
+		case '\\':
+			i++
+			if i == len(s) {
+				return "", "", errors.New("unterminated string")
+			}
+			switch s[i] {
+			case '"', '\\':
+				value.WriteByte(s[i])
+			case 'n':
+				value.WriteByte('\n')
+			case 't':
+				value.WriteByte('\t')
+			case 'r':
+				value.WriteByte('\r')
+			default:
+				return "", "", fmt.Errorf("invalid escape \\%c", s[i])
+			}
+		default:
+			value.WriteByte(s[i])
+		}
+	}
+	return "", "", errors.New("unterminated string")
+}
+
+// readProjectVersion reads the version from a .nvurc. A file that can't be
+// parsed, or sets an invalid version, is a sourceError.
+func readProjectVersion(path string) (string, versionSource, error) {
+	source := versionSource{path: path}
+	content, err := readSourceFile(path)
+	if err != nil {
+		return "", source, err
+	}
+
+	project, err := parseProjectFile(string(content))
+	if err != nil {
+		return "", source, &sourceError{source: source, err: err}
+	}
+	projectFileMemo[filepath.Dir(path)] = project
+	if project.version == "" {
+		return "", source, nil
+	}
+	if project.line > 0 {
+		source.line = project.line
+	} else {
+		source.field = "version"
+	}
+	if project.version == disabledVersion {
+		return project.version, source, nil // only valid on its own, not in a list
+	}
+	if err := validateVersionExpression(project.version); err != nil {
+		return "", source, &sourceError{source: source, err: err}
+	}
+	return project.version, source, nil
+}
+
+// readProjectFile returns dir's .nvurc, or nil if there is none or it can't
+// be parsed
+func readProjectFile(dir string) *projectFile {
+	if project, ok := projectFileMemo[dir]; ok {
+		return project
+	}
+	var project *projectFile
+	if content, err := readSourceFile(filepath.Join(dir, projectSettingsFile)); err == nil {
+		if parsed, err := parseProjectFile(string(content)); err == nil {
+			project = parsed
+		}
+	}
+	projectFileMemo[dir] = project
+	return project
+}
+
+// getProject returns the nearest .nvurc and its directory, looking up from
+// the directory resolution starts from within the same boundaries as the
+// version file walk. It doesn't depend on where the version came from, so a
+// project's settings also apply under NVU_VERSION, the global default or a
+// subdirectory's own .nvmrc.
+func getProject() (*projectFile, string) {
+	dir, err := getWorkingDirectory()
+	if err != nil {
+		return nil, ""
+	}
+	cfg := getConfig()
+	for {
+		if cfg.IncludeNodeModules || !isInsideNodeModules(dir) {
+			if _, err := os.Stat(filepath.Join(dir, projectSettingsFile)); err == nil {
+				return readProjectFile(dir), dir
+			}
+		}
+		if cfg.StopAtGitRoot && isRepositoryRoot(dir) {
+			return nil, ""
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir || isCeilingDirectory(parent) {
+			return nil, ""
+		}
+		dir = parent
+	}
+}
+
+// getProjectSetting returns a setting of the nearest project
+func getProjectSetting(key string) string {
+	if project, _ := getProject(); project != nil {
+		return project.settings[key]
+	}
+	return ""
+}
+
+// getProjectEnv returns the environment overrides env with those of the
+// nearest project applied on top: its env and unset entries and its
+// pathPrepend directories, which go first on PATH
+func getProjectEnv(env map[string]string) map[string]string {
+	project, dir := getProject()
+	if project == nil || len(project.env) == 0 && len(project.unset) == 0 && len(project.pathPrepend) == 0 {
+		return env
+	}
+
+	merged := make(map[string]string)
+	for key, value := range env {
+		merged[key] = value
+	}
+	for key, value := range project.env {
+		merged[key] = value
+	}
+	for _, key := range project.unset {
+		merged[key] = "" // an empty override removes the variable
+	}
+
+	if len(project.pathPrepend) > 0 {
+		path, ok := merged["PATH"]
+		if !ok {
+			path = getPathEnv()
+		}
+		var dirs []string
+		for _, entry := range project.pathPrepend {
+			if !filepath.IsAbs(entry) {
+				entry = filepath.Join(dir, entry)
+			}
+			dirs = append(dirs, filepath.Clean(entry))
+		}
+		// drop them from where they already are, as when a parent shim added them
+		result := append([]string{}, dirs...)
+		for _, entry := range strings.Split(path, string(os.PathListSeparator)) {
+			duplicate := entry == ""
+			for _, added := range dirs {
+				duplicate = duplicate || pathsEqual(filepath.Clean(entry), added)
+			}
+			if !duplicate {
+				result = append(result, entry)
+			}
+		}
+		merged["PATH"] = strings.Join(result, string(os.PathListSeparator))
+	}
+	return merged
+}
+
+// isDefaultSource reports whether a version came from ~/.nvu/default
+func isDefaultSource(source versionSource) bool {
+	nvuHome, err := getNvuHome()
+	return err == nil && source.path != "" && pathsEqual(source.path, filepath.Join(nvuHome, "default"))
+}
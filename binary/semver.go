@@ -0,0 +1,238 @@
+package main
+
+import (
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Selection policies for choosing between several installed versions that all
+// match a version expression (e.g. "20" with v20.9.0 and v20.19.6 installed)
+const (
+	selectHighest   = "highest"
+	selectLowest    = "lowest"
+	selectPreferLTS = "prefer-lts"
+)
+
+// semver is a parsed Node version such as "v20.19.6" or "22.0.0-rc.1"
+type semver struct {
+	major      int
+	minor      int
+	patch      int
+	prerelease []string
+}
+
+// installedVersion is a directory in ~/.nvu/installed whose name parses as a version
+type installedVersion struct {
+	name    string
+	version semver
+}
+
+// parseSemver parses a full major.minor.patch version with an optional 'v'
+// prefix and prerelease. Build metadata after '+' is ignored.
+func parseSemver(s string) (semver, bool) {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "v")
+	if i := strings.IndexByte(s, '+'); i >= 0 {
+		s = s[:i]
+	}
+
+	var v semver
+	if i := strings.IndexByte(s, '-'); i >= 0 {
+		if i == len(s)-1 {
+			return semver{}, false
+		}
+		v.prerelease = strings.Split(s[i+1:], ".")
+		s = s[:i]
+	}
+
+	parts := strings.Split(s, ".")
+	if len(parts) != 3 {
+		return semver{}, false
+	}
+	nums := make([]int, 3)
+	for i, part := range parts {
+		n, ok := parseNumericPart(part)
+		if !ok {
+			return semver{}, false
+		}
+		nums[i] = n
+	}
+	v.major, v.minor, v.patch = nums[0], nums[1], nums[2]
+	return v, true
+}
+
+// parseNumericPart parses a non-empty string of ASCII digits
+func parseNumericPart(s string) (int, bool) {
+	if s == "" {
+		return 0, false
+	}
+	for _, char := range s {
+		if char < '0' || char > '9' {
+			return 0, false
+		}
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, false
+	}
+	return n, true
+}
+
+// isPrerelease reports whether the version carries a prerelease tag
+func (v semver) isPrerelease() bool {
+	return len(v.prerelease) > 0
+}
+
+// String formats the version without the 'v' prefix
+func (v semver) String() string {
+	s := strconv.Itoa(v.major) + "." + strconv.Itoa(v.minor) + "." + strconv.Itoa(v.patch)
+	if v.isPrerelease() {
+		s += "-" + strings.Join(v.prerelease, ".")
+	}
+	return s
+}
+
+// compareSemver orders versions by semver precedence: numerically by
+// major.minor.patch, with a prerelease sorting before its release.
+// Returns a negative number if a < b, positive if a > b and 0 if equal.
+func compareSemver(a, b semver) int {
+	if a.major != b.major {
+		return a.major - b.major
+	}
+	if a.minor != b.minor {
+		return a.minor - b.minor
+	}
+	if a.patch != b.patch {
+		return a.patch - b.patch
+	}
+	return comparePrerelease(a.prerelease, b.prerelease)
+}
+
+// comparePrerelease compares prerelease identifiers as described by semver:
+// no prerelease ranks highest, numeric identifiers compare numerically and
+// rank below alphanumeric ones, and a shorter list ranks below a longer one
+// with the same prefix
+func comparePrerelease(a, b []string) int {
+	if len(a) == 0 || len(b) == 0 {
+		return len(b) - len(a)
+	}
+	for i := 0; i < len(a) && i < len(b); i++ {
+		aNum, aIsNum := parseNumericPart(a[i])
+		bNum, bIsNum := parseNumericPart(b[i])
+		switch {
+		case aIsNum && bIsNum:
+			if aNum != bNum {
+				return aNum - bNum
+			}
+		case aIsNum:
+			return -1
+		case bIsNum:
+			return 1
+		default:
+			if c := strings.Compare(a[i], b[i]); c != 0 {
+				return c
+			}
+		}
+	}
+	return len(a) - len(b)
+}
+
+// partialVersion is a version prefix like "20", "20.19" or "20.19.6" used to
+// match installed versions. A prerelease may only be given with all three parts.
+type partialVersion struct {
+	parts      []int
+	prerelease []string
+}
+
+// parsePartialVersion parses a version prefix with an optional 'v' prefix
+func parsePartialVersion(s string) (partialVersion, bool) {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "v")
+
+	var p partialVersion
+	if i := strings.IndexByte(s, '-'); i >= 0 {
+		if i == len(s)-1 {
+			return partialVersion{}, false
+		}
+		p.prerelease = strings.Split(s[i+1:], ".")
+		s = s[:i]
+	}
+
+	parts := strings.Split(s, ".")
+	if len(parts) > 3 || (p.prerelease != nil && len(parts) != 3) {
+		return partialVersion{}, false
+	}
+	for _, part := range parts {
+		n, ok := parseNumericPart(part)
+		if !ok {
+			return partialVersion{}, false
+		}
+		p.parts = append(p.parts, n)
+	}
+	return p, true
+}
+
+// matches reports whether v starts with this prefix. Prereleases only match
+// when the prefix explicitly asks for one, so "22" never selects v22.0.0-rc.1.
+func (p partialVersion) matches(v semver) bool {
+	fields := []int{v.major, v.minor, v.patch}
+	for i, n := range p.parts {
+		if fields[i] != n {
+			return false
+		}
+	}
+	if !v.isPrerelease() {
+		return p.prerelease == nil
+	}
+	if p.prerelease == nil || len(p.prerelease) > len(v.prerelease) {
+		return false
+	}
+	for i, id := range p.prerelease {
+		if v.prerelease[i] != id {
+			return false
+		}
+	}
+	return true
+}
+
+// listInstalledVersions returns the version directories in versionsDir in
+// ascending semver order. Entries that don't parse as versions are skipped.
+func listInstalledVersions(versionsDir string) ([]installedVersion, error) {
+	entries, err := os.ReadDir(versionsDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var versions []installedVersion
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		v, ok := parseSemver(entry.Name())
+		if !ok {
+			continue
+		}
+		versions = append(versions, installedVersion{name: entry.Name(), version: v})
+	}
+
+	sort.SliceStable(versions, func(i, j int) bool {
+		return compareSemver(versions[i].version, versions[j].version) < 0
+	})
+	return versions, nil
+}
+
+// selectVersion picks one of the matching versions (sorted ascending) using
+// the given policy. Unknown or empty policies behave like "highest".
+func selectVersion(versionsDir string, matches []installedVersion, policy string) string {
+	switch policy {
+	case selectLowest:
+		return matches[0].name
+	case selectPreferLTS:
+		for i := len(matches) - 1; i >= 0; i-- {
+			if installedLTSCodename(versionsDir, matches[i]) != "" {
+				return matches[i].name
+			}
+		}
+	}
+	return matches[len(matches)-1].name
+}
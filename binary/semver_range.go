@@ -0,0 +1,345 @@
+package main
+
+import (
+	"strings"
+)
+
+// comparator is a single constraint like ">=20.0.0" or "<21.0.0-0"
+type comparator struct {
+	op      string
+	version semver
+}
+
+// versionRange is an npm-style range: a union ("||") of comparator sets, each
+// of which must be satisfied in full
+type versionRange [][]comparator
+
+// rangeOperators are the comparison prefixes, longest first so ">=" wins over ">"
+var rangeOperators = []string{">=", "<=", ">", "<", "="}
+
+// parseRange parses npm-style ranges: comparators (">=18 <21"), caret ("^20"),
+// tilde ("~20.1"), x-ranges ("18.x", "*"), hyphen ranges ("18 - 20") and
+// unions of those joined by "||"
+func parseRange(s string) (versionRange, bool) {
+	var r versionRange
+	for _, part := range strings.Split(s, "||") {
+		set, ok := parseComparatorSet(strings.TrimSpace(part))
+		if !ok {
+			return nil, false
+		}
+		r = append(r, set)
+	}
+	return r, true
+}
+
+// parseComparatorSet parses one side of a "||" union
+func parseComparatorSet(s string) ([]comparator, bool) {
+	tokens := strings.Fields(s)
+
+	// an empty set matches any release, like "*"
+	if len(tokens) == 0 {
+		return []comparator{{op: ">=", version: semver{}}}, true
+	}
+
+	// hyphen range: "<from> - <to>"
+	if len(tokens) == 3 && tokens[1] == "-" {
+		return parseHyphenRange(tokens[0], tokens[2])
+	}
+
+	// join operators written apart from their version (">= 18")
+	var terms []string
+	for i := 0; i < len(tokens); i++ {
+		token := tokens[i]
+		if isBareOperator(token) && i+1 < len(tokens) {
+			token += tokens[i+1]
+			i++
+		}
+		terms = append(terms, token)
+	}
+
+	var set []comparator
+	for _, term := range terms {
+		comparators, ok := parseRangeTerm(term)
+		if !ok {
+			return nil, false
+		}
+		set = append(set, comparators...)
+	}
+	return set, true
+}
+
+// isBareOperator reports whether token is only an operator, with no version
+func isBareOperator(token string) bool {
+	switch token {
+	case ">=", "<=", ">", "<", "=", "^", "~", "~>":
+		return true
+	}
+	return false
+}
+
+// parseRangeTerm desugars a single term into primitive comparators
+func parseRangeTerm(term string) ([]comparator, bool) {
+	switch {
+	case strings.HasPrefix(term, "^"):
+		x, ok := parseXRange(term[1:])
+		if !ok {
+			return nil, false
+		}
+		return caretComparators(x), true
+	case strings.HasPrefix(term, "~>"):
+		x, ok := parseXRange(term[2:])
+		if !ok {
+			return nil, false
+		}
+		return tildeComparators(x), true
+	case strings.HasPrefix(term, "~"):
+		x, ok := parseXRange(term[1:])
+		if !ok {
+			return nil, false
+		}
+		return tildeComparators(x), true
+	}
+
+	op := ""
+	for _, candidate := range rangeOperators {
+		if strings.HasPrefix(term, candidate) {
+			op = candidate
+			break
+		}
+	}
+	x, ok := parseXRange(term[len(op):])
+	if !ok {
+		return nil, false
+	}
+	return primitiveComparators(op, x), true
+}
+
+// xRange is a possibly partial version where missing or wildcard ("x", "X",
+// "*") parts are recorded by count
+type xRange struct {
+	parts      []int // only the concrete leading parts
+	prerelease []string
+}
+
+// parseXRange parses "20", "20.x", "20.1.*", "*" or a full version
+func parseXRange(s string) (xRange, bool) {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "=")
+	s = strings.TrimPrefix(s, "v")
+	if s == "" {
+		return xRange{}, false
+	}
+	if i := strings.IndexByte(s, '+'); i >= 0 {
+		s = s[:i]
+	}
+
+	var x xRange
+	if i := strings.IndexByte(s, '-'); i >= 0 {
+		if i == len(s)-1 {
+			return xRange{}, false
+		}
+		x.prerelease = strings.Split(s[i+1:], ".")
+		s = s[:i]
+	}
+
+	parts := strings.Split(s, ".")
+	if len(parts) > 3 {
+		return xRange{}, false
+	}
+	wildcard := false
+	for _, part := range parts {
+		if part == "x" || part == "X" || part == "*" {
+			wildcard = true
+			continue
+		}
+		n, ok := parseNumericPart(part)
+		if !ok || wildcard {
+			// concrete parts can't follow a wildcard ("1.x.3")
+			return xRange{}, false
+		}
+		x.parts = append(x.parts, n)
+	}
+	if x.prerelease != nil && len(x.parts) != 3 {
+		return xRange{}, false
+	}
+	return x, true
+}
+
+// lower returns the lowest version in the x-range, with missing parts as 0
+func (x xRange) lower() semver {
+	v := semver{prerelease: x.prerelease}
+	fields := []*int{&v.major, &v.minor, &v.patch}
+	for i, n := range x.parts {
+		*fields[i] = n
+	}
+	return v
+}
+
+// upper returns the exclusive bound just above the x-range (e.g. 21.0.0-0 for
+// "20"), which only makes sense when at least one part is missing
+func (x xRange) upper() semver {
+	switch len(x.parts) {
+	case 1:
+		return semver{major: x.parts[0] + 1, prerelease: []string{"0"}}
+	default:
+		return semver{major: x.parts[0], minor: x.parts[1] + 1, prerelease: []string{"0"}}
+	}
+}
+
+// primitiveComparators desugars an operator applied to a possibly partial version
+func primitiveComparators(op string, x xRange) []comparator {
+	// "*" or "x": anything for >=, <=, =; nothing for > and <
+	if len(x.parts) == 0 {
+		if op == ">" || op == "<" {
+			return []comparator{{op: "<", version: semver{prerelease: []string{"0"}}}}
+		}
+		return []comparator{{op: ">=", version: semver{}}}
+	}
+
+	if len(x.parts) == 3 {
+		if op == "" {
+			op = "="
+		}
+		return []comparator{{op: op, version: x.lower()}}
+	}
+
+	switch op {
+	case ">":
+		return []comparator{{op: ">=", version: x.upper()}}
+	case ">=":
+		return []comparator{{op: ">=", version: x.lower()}}
+	case "<":
+		bound := x.lower()
+		bound.prerelease = []string{"0"}
+		return []comparator{{op: "<", version: bound}}
+	case "<=":
+		return []comparator{{op: "<", version: x.upper()}}
+	default:
+		return []comparator{{op: ">=", version: x.lower()}, {op: "<", version: x.upper()}}
+	}
+}
+
+// tildeComparators desugars "~20.1.2": patch-level changes if a minor is
+// given, minor-level changes otherwise
+func tildeComparators(x xRange) []comparator {
+	switch len(x.parts) {
+	case 0:
+		return []comparator{{op: ">=", version: semver{}}}
+	case 1:
+		return []comparator{{op: ">=", version: x.lower()}, {op: "<", version: x.upper()}}
+	default:
+		return []comparator{
+			{op: ">=", version: x.lower()},
+			{op: "<", version: semver{major: x.parts[0], minor: x.parts[1] + 1, prerelease: []string{"0"}}},
+		}
+	}
+}
+
+// caretComparators desugars "^20.1.2": changes that don't modify the left-most
+// non-zero part
+func caretComparators(x xRange) []comparator {
+	lower := x.lower()
+	switch {
+	case len(x.parts) == 0:
+		return []comparator{{op: ">=", version: semver{}}}
+	case x.parts[0] != 0 || len(x.parts) == 1:
+		return []comparator{{op: ">=", version: lower}, {op: "<", version: semver{major: x.parts[0] + 1, prerelease: []string{"0"}}}}
+	case x.parts[1] != 0 || len(x.parts) == 2:
+		return []comparator{{op: ">=", version: lower}, {op: "<", version: semver{minor: x.parts[1] + 1, prerelease: []string{"0"}}}}
+	default:
+		return []comparator{{op: ">=", version: lower}, {op: "<", version: semver{patch: x.parts[2] + 1, prerelease: []string{"0"}}}}
+	}
+}
+
+// parseHyphenRange desugars "from - to" into an inclusive range, where a
+// partial upper bound includes everything it matches ("18 - 20" allows 20.x)
+func parseHyphenRange(from string, to string) ([]comparator, bool) {
+	low, ok := parseXRange(from)
+	if !ok {
+		return nil, false
+	}
+	high, ok := parseXRange(to)
+	if !ok {
+		return nil, false
+	}
+
+	set := []comparator{{op: ">=", version: low.lower()}}
+	switch len(high.parts) {
+	case 0:
+	case 3:
+		set = append(set, comparator{op: "<=", version: high.lower()})
+	default:
+		set = append(set, comparator{op: "<", version: high.upper()})
+	}
+	return set, true
+}
+
+// lower returns the lowest version the range allows, from the ">=", ">" and
+// "=" comparators of its sets (0.0.0 for a set without any)
+func (r versionRange) lower() semver {
+	var lowest semver
+	for i, set := range r {
+		var bound semver
+		for _, c := range set {
+			if c.op != "<" && c.op != "<=" && compareSemver(c.version, bound) > 0 {
+				bound = c.version
+			}
+		}
+		if i == 0 || compareSemver(bound, lowest) < 0 {
+			lowest = bound
+		}
+	}
+	return lowest
+}
+
+// satisfies reports whether v passes a single comparator
+func (c comparator) satisfies(v semver) bool {
+	cmp := compareSemver(v, c.version)
+	switch c.op {
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	default:
+		return cmp == 0
+	}
+}
+
+// satisfiedBy reports whether v is in the range. As with npm, a prerelease
+// only satisfies a comparator set that names a prerelease of the same
+// major.minor.patch, so ">=20" never selects v22.0.0-rc.1.
+func (r versionRange) satisfiedBy(v semver) bool {
+	for _, set := range r {
+		if setSatisfiedBy(set, v) {
+			return true
+		}
+	}
+	return false
+}
+
+func setSatisfiedBy(set []comparator, v semver) bool {
+	for _, c := range set {
+		if !c.satisfies(v) {
+			return false
+		}
+	}
+	if !v.isPrerelease() {
+		return true
+	}
+	for _, c := range set {
+		cv := c.version
+		if cv.isPrerelease() && !isZeroPrerelease(cv) && cv.major == v.major && cv.minor == v.minor && cv.patch == v.patch {
+			return true
+		}
+	}
+	return false
+}
+
+// isZeroPrerelease reports whether v is one of the "-0" exclusive bounds
+// produced by desugaring rather than a prerelease the user asked for
+func isZeroPrerelease(v semver) bool {
+	return len(v.prerelease) == 1 && v.prerelease[0] == "0"
+}
@@ -0,0 +1,13 @@
+//go:build windows
+
+package main
+
+import (
+	"os"
+)
+
+// fileInode returns 0 on Windows, where os.FileInfo carries no file index;
+// mtime and size alone identify a file there
+func fileInode(info os.FileInfo) uint64 {
+	return 0
+}
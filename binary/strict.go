@@ -0,0 +1,30 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// Exit codes for fallbacks refused in strict mode. They sit above the codes
+// node and npm use themselves, so CI can tell a misconfigured machine from a
+// failing command.
+const (
+	exitNoVersion       = 64 // no version configured; would have run the system binary
+	exitSystemFallback  = 65 // tool not in the resolved version; would have run the system one
+	exitDefaultFallback = 66 // tool not in the resolved version; would have run the default version's
+)
+
+// reportFallback is called just before the shim falls back to a binary other
+// than the one the resolved version provides. In strict mode the fallback is
+// an error and the process exits with code; otherwise it goes ahead, with a
+// warning when warnFallback is set.
+func reportFallback(code int, message string) {
+	cfg := getConfig()
+	if cfg.Strict {
+		fmt.Fprintf(os.Stderr, "nvu error: %s (refused in strict mode)\n", message)
+		os.Exit(code)
+	}
+	if cfg.WarnFallback {
+		fmt.Fprintf(os.Stderr, "nvu warning: %s\n", message)
+	}
+}
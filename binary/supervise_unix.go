@@ -0,0 +1,188 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+	"unsafe"
+)
+
+// When the shim has to run a command and then do more work (npm -g with shim
+// reconciliation, auto-install), it supervises the child instead of exec'ing
+// it. When the shim is in the terminal's foreground, the child gets a process
+// group of its own that takes the terminal, so Ctrl+C and Ctrl+Z reach the
+// child alone. Otherwise it stays in the shim's group, so killing that group
+// (as timeout(1) or a CI runner does) kills the child too. Signals sent to
+// the shim are forwarded to the child, a suspended child suspends the shim
+// with it, and the exit status is mirrored, with death by signal n reported
+// as 128+n. The shim outlives the child either way, so its post-exit work
+// still runs.
+
+// forwardedSignals are passed on to the supervised child
+var forwardedSignals = []os.Signal{
+	syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGQUIT,
+	syscall.SIGWINCH, syscall.SIGUSR1, syscall.SIGUSR2, syscall.SIGTSTP,
+}
+
+// runSupervised starts cmd and waits for it, returning its exit code. The
+// error is only set if the command couldn't be started or waited for.
+func runSupervised(cmd *exec.Cmd) (int, error) {
+	ttyFd := getControllingTerminalFd()
+	foreground := ttyFd >= 0 && isForegroundProcessGroup(ttyFd, syscall.Getpgrp())
+
+	if foreground {
+		cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true, Foreground: true, Ctty: ttyFd}
+	}
+
+	signals := make(chan os.Signal, len(forwardedSignals))
+	signal.Notify(signals, forwardedSignals...)
+	defer signal.Stop(signals)
+	if ttyFd >= 0 {
+		// taking the terminal back from the child is done from the background
+		signal.Ignore(syscall.SIGTTOU)
+		defer restoreDefaultSignal(syscall.SIGTTOU)
+	}
+
+	if err := cmd.Start(); err != nil {
+		return 0, err
+	}
+	pid := cmd.Process.Pid
+	target := pid // signals go to the child's own group when it has one
+	if foreground {
+		target = -pid
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case sig := <-signals:
+				syscall.Kill(target, sig.(syscall.Signal))
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	for {
+		var status syscall.WaitStatus
+		_, err := syscall.Wait4(pid, &status, syscall.WUNTRACED, nil)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+
+		if ttyFd >= 0 && isForegroundProcessGroup(ttyFd, pid) {
+			setForegroundProcessGroup(ttyFd, syscall.Getpgrp())
+		}
+		switch {
+		case status.Exited():
+			return status.ExitStatus(), nil
+		case status.Signaled():
+			return 128 + int(status.Signal()), nil
+		case status.Stopped():
+			// suspend along with the child so the shell sees the job stop, then
+			// give the terminal back and resume the child when we're continued
+			syscall.Kill(os.Getpid(), syscall.SIGSTOP)
+			if ttyFd >= 0 && isForegroundProcessGroup(ttyFd, syscall.Getpgrp()) {
+				setForegroundProcessGroup(ttyFd, pid)
+			}
+			syscall.Kill(target, syscall.SIGCONT)
+		}
+	}
+}
+
+// exitStatus returns the exit code of a finished command, with death by
+// signal n reported as 128+n, as runSupervised and the shell do
+func exitStatus(state *os.ProcessState) int {
+	if status, ok := state.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return 128 + int(status.Signal())
+	}
+	return state.ExitCode()
+}
+
+// setNewProcessGroup makes cmd the leader of a process group of its own, so
+// killProcessGroup reaches everything it starts
+func setNewProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// forwardSignalsToGroup passes the signals that would end the shim on to the
+// group of a command started with setNewProcessGroup until the returned
+// function is called, so killing the shim's group ends the command too
+func forwardSignalsToGroup(cmd *exec.Cmd) func() {
+	signals := make(chan os.Signal, 4)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGQUIT)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case sig := <-signals:
+				syscall.Kill(-cmd.Process.Pid, sig.(syscall.Signal))
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		signal.Stop(signals)
+		close(done)
+	}
+}
+
+// killProcessGroup kills a command started with setNewProcessGroup along
+// with every process in its group
+func killProcessGroup(cmd *exec.Cmd) error {
+	return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
+
+// restoreDefaultSignal undoes signal.Ignore for programs exec'd later.
+// signal.Reset alone leaves the signal ignored, which exec passes on.
+func restoreDefaultSignal(sig os.Signal) {
+	signal.Notify(make(chan os.Signal, 1), sig)
+	signal.Reset(sig)
+}
+
+// getControllingTerminalFd returns the first of stdin, stdout and stderr that
+// is the controlling terminal, or -1
+func getControllingTerminalFd() int {
+	for fd := 0; fd <= 2; fd++ {
+		if _, err := getForegroundProcessGroup(fd); err == nil {
+			return fd
+		}
+	}
+	return -1
+}
+
+// getForegroundProcessGroup returns the foreground process group of the
+// terminal on fd, failing if fd isn't the controlling terminal
+func getForegroundProcessGroup(fd int) (int, error) {
+	var pgrp int32
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), uintptr(syscall.TIOCGPGRP), uintptr(unsafe.Pointer(&pgrp)))
+	if errno != 0 {
+		return 0, errno
+	}
+	return int(pgrp), nil
+}
+
+// isForegroundProcessGroup reports whether pgrp owns the terminal on fd
+func isForegroundProcessGroup(fd int, pgrp int) bool {
+	foreground, err := getForegroundProcessGroup(fd)
+	return err == nil && foreground == pgrp
+}
+
+// setForegroundProcessGroup hands the terminal on fd to pgrp
+func setForegroundProcessGroup(fd int, pgrp int) error {
+	value := int32(pgrp)
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), uintptr(syscall.TIOCSPGRP), uintptr(unsafe.Pointer(&value)))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
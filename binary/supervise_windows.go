@@ -0,0 +1,51 @@
+//go:build windows
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"os/signal"
+)
+
+// exitStatus returns the exit code of a finished command
+func exitStatus(state *os.ProcessState) int {
+	return state.ExitCode()
+}
+
+// setNewProcessGroup does nothing on Windows, where killProcessGroup can only
+// reach the command itself
+func setNewProcessGroup(cmd *exec.Cmd) {}
+
+// forwardSignalsToGroup does nothing on Windows, where console signals reach
+// every process on the console already
+func forwardSignalsToGroup(cmd *exec.Cmd) func() {
+	return func() {}
+}
+
+// killProcessGroup kills the command
+func killProcessGroup(cmd *exec.Cmd) error {
+	return cmd.Process.Kill()
+}
+
+// runSupervised starts cmd and waits for it, returning its exit code. The
+// error is only set if the command couldn't be started or waited for.
+// Ctrl+C and Ctrl+Break go to every process on the console, so the child
+// already gets them; the shim only has to survive them to finish its work.
+func runSupervised(cmd *exec.Cmd) (int, error) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt)
+	defer signal.Stop(signals)
+
+	if err := cmd.Start(); err != nil {
+		return 0, err
+	}
+	err := cmd.Wait()
+	if exitError, ok := err.(*exec.ExitError); ok {
+		return exitError.ExitCode(), nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return 0, nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"strings"
+)
+
+// toolVersionsFile is the asdf/mise file that pins versions for many tools,
+// one per line: "nodejs 20.11.1 18.19.0"
+const toolVersionsFile = ".tool-versions"
+
+// readToolVersionsFile reads the nodejs (or node, as mise also accepts) entry
+// from a .tool-versions file, with its line. Like asdf, later versions on the
+// line are fallbacks: the first one that is installed (or "system") wins. If
+// none are installed the first is returned, so the usual "not installed"
+// error names it. A version nvu doesn't understand is a sourceError.
+func readToolVersionsFile(path string) (string, int, error) {
+	content, err := readSourceFile(path)
+	if err != nil {
+		return "", 0, err
+	}
+
+	versions, line := parseToolVersions(string(content))
+	if len(versions) == 0 {
+		return "", 0, nil
+	}
+	for _, version := range versions {
+		if err := validateVersionExpression(version); err != nil {
+			return "", line, &sourceError{source: versionSource{path: path, line: line}, err: err}
+		}
+	}
+
+	for _, version := range versions {
+		if isVersionInstalled(version) {
+			return version, line, nil
+		}
+	}
+	return versions[0], line, nil
+}
+
+// parseToolVersions returns the versions listed for node in a .tool-versions
+// file, in preference order, and the 1-based line they are on. Comments are
+// stripped, and ref: and path: versions (built from source or a local
+// directory) are skipped since they don't name an nvu install.
+func parseToolVersions(content string) ([]string, int) {
+	content = strings.TrimPrefix(content, utf8BOM)
+	for i, line := range strings.Split(content, "\n") {
+		fields := strings.Fields(stripComment(line))
+		if len(fields) < 2 || (fields[0] != "nodejs" && fields[0] != "node") {
+			continue
+		}
+
+		var versions []string
+		for _, version := range fields[1:] {
+			if strings.HasPrefix(version, "ref:") || strings.HasPrefix(version, "path:") {
+				continue
+			}
+			versions = append(versions, version)
+		}
+		return versions, i + 1
+	}
+	return nil, 0
+}
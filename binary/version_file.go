@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// utf8BOM is the byte order mark some Windows editors put at the start of files
+const utf8BOM = "\xef\xbb\xbf"
+
+// latestAliases name the newest release; offline they select the newest
+// installed version
+var latestAliases = []string{"node", "latest", "current", "stable"}
+
+// readVersionFileLine reads a version from a version file such as .nvmrc and
+// returns the 1-based line it was found on. As with nvm, blank lines and '#'
+// comments (whole-line or trailing) are skipped and the first remaining line
+// is the version; a BOM and CRLF line endings are tolerated. A line that
+// isn't a valid version expression is a sourceError naming the file and line.
+func readVersionFileLine(path string) (string, int, error) {
+	content, err := readSourceFile(path)
+	if err != nil {
+		return "", 0, err
+	}
+
+	version, line := parseVersionFile(string(content))
+	if version == "" {
+		return "", 0, nil
+	}
+	if err := validateVersionExpression(version); err != nil {
+		return "", line, &sourceError{source: versionSource{path: path, line: line}, err: err}
+	}
+	return version, line, nil
+}
+
+// parseVersionFile returns the first non-blank, non-comment line of a
+// version file with any trailing comment removed, and its 1-based line number
+func parseVersionFile(content string) (string, int) {
+	content = strings.TrimPrefix(content, utf8BOM)
+	for i, line := range strings.Split(content, "\n") {
+		if line = stripComment(strings.TrimRight(line, "\r")); line != "" {
+			return line, i + 1
+		}
+	}
+	return "", 0
+}
+
+// stripComment removes a '#' comment and surrounding whitespace from a line
+func stripComment(line string) string {
+	if i := strings.IndexByte(line, '#'); i >= 0 {
+		line = line[:i]
+	}
+	return strings.TrimSpace(line)
+}
+
+// validateVersionExpression checks a version expression against what nvu
+// understands: versions ("20", "v20.19.6", "22.0.0-rc.1"), npm-style ranges
+// (">=18 <21", "^20", "18.x"), comma-separated lists of those ("22,20,18")
+// and aliases ("system", "lts/*", "lts/iron", "lts/-1", "node", "engines").
+// "off" is not among them: only a .nvurc can turn routing off.
+func validateVersionExpression(version string) error {
+	for _, item := range strings.Split(version, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			return fmt.Errorf("invalid version %q: empty list entry", version)
+		}
+		if !isValidVersionItem(item) {
+			return fmt.Errorf("invalid version %q", item)
+		}
+	}
+	return nil
+}
+
+// isValidVersionItem reports whether a single (non-list) expression is valid
+func isValidVersionItem(item string) bool {
+	switch {
+	case item == "system" || item == "engines" || isLatestAlias(item):
+		return true
+	case isLTSAlias(item):
+		return isValidLTSAlias(item)
+	}
+	return isVersionSyntax(item) || isKnownAlias(item)
+}
+
+// isVersionSyntax reports whether item parses as a partial version or a range
+func isVersionSyntax(item string) bool {
+	if _, ok := parsePartialVersion(item); ok {
+		return true
+	}
+	_, ok := parseRange(item)
+	return ok
+}
+
+// isLatestAlias reports whether version is one of the newest-release aliases
+func isLatestAlias(version string) bool {
+	for _, alias := range latestAliases {
+		if strings.EqualFold(version, alias) {
+			return true
+		}
+	}
+	return false
+}
+
+// isValidLTSAlias checks the part after "lts/": "*", a codename or "-N"
+func isValidLTSAlias(alias string) bool {
+	i := strings.IndexByte(alias, '/')
+	if i < 0 {
+		return strings.EqualFold(alias, "lts")
+	}
+	name := alias[i+1:]
+	if name == "*" {
+		return true
+	}
+	if strings.HasPrefix(name, "-") {
+		_, ok := parseNumericPart(name[1:])
+		return ok
+	}
+	if name == "" {
+		return false
+	}
+	for _, char := range name {
+		if !(char >= 'a' && char <= 'z' || char >= 'A' && char <= 'Z') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// isVersionList reports whether a version expression is a preference list
+// like "22,20,18"
+func isVersionList(version string) bool {
+	return strings.Contains(version, ",")
+}
+
+// selectFromList picks the first available entry of a preference list:
+// "system" when there is a system node, anything else when an installed
+// version matches it. Entries that are aliases come back expanded. When a
+// later entry wins, the skipped ones are noted on stderr. If nothing is
+// available the first entry is returned, so errors and install hints name the
+// preferred version. Other expressions are returned unchanged.
+func selectFromList(version string) string {
+	if !isVersionList(version) {
+		return version
+	}
+
+	var items []string
+	for _, item := range strings.Split(version, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	if len(items) == 0 {
+		return version
+	}
+
+	for i, item := range items {
+		expanded, ok := findAvailableListItem(item)
+		if !ok {
+			continue
+		}
+		if i > 0 {
+			markUncacheable()
+			verb := "is"
+			if i > 1 {
+				verb = "are"
+			}
+			fmt.Fprintf(os.Stderr, "nvu note: %s %s not installed, using %s from %q\n", strings.Join(items[:i], ", "), verb, item, version)
+		}
+		return expanded
+	}
+	if expanded, err := expandAlias(items[0]); err == nil {
+		return expanded
+	}
+	return items[0]
+}
+
+// findAvailableListItem expands one entry of a preference list and reports
+// whether it can be used without installing anything
+func findAvailableListItem(item string) (string, bool) {
+	expanded, err := expandAlias(item)
+	if err != nil {
+		return "", false
+	}
+	if expanded == "system" {
+		markUncacheable()
+		return expanded, resolveSystemBinary("node") != ""
+	}
+
+	nvuHome, err := getNvuHome()
+	if err != nil {
+		return "", false
+	}
+	_, err = resolveInstalledVersion(filepath.Join(nvuHome, "installed"), expanded)
+	return expanded, err == nil
+}
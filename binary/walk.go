@@ -0,0 +1,63 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Boundaries of the version file walk in findVersionInParents. Without them
+// the walk goes all the way to the filesystem root, so a stray ~/.nvmrc wins
+// and a package's published .nvmrc applies inside node_modules.
+
+// startDirectory replaces the current directory as the start of the walk
+// when set (nvu which --dir, or the entry script's directory)
+var startDirectory string
+
+// getWorkingDirectory returns the directory resolution starts from. The
+// logical path (as the shell sees it, through symlinks) is used unless the
+// cwd setting is "physical".
+func getWorkingDirectory() (string, error) {
+	cwd := startDirectory
+	if cwd == "" {
+		var err error
+		if cwd, err = os.Getwd(); err != nil {
+			return "", err
+		}
+	}
+	if getConfig().Cwd == "physical" {
+		if physical, err := filepath.EvalSymlinks(cwd); err == nil {
+			return physical, nil
+		}
+	}
+	return cwd, nil
+}
+
+// isCeilingDirectory reports whether the walk must not continue into dir.
+// As with GIT_CEILING_DIRECTORIES, a ceiling itself is never searched.
+func isCeilingDirectory(dir string) bool {
+	for _, ceiling := range getConfig().CeilingDirectories {
+		if pathsEqual(filepath.Clean(ceiling), dir) {
+			return true
+		}
+	}
+	return false
+}
+
+// isRepositoryRoot reports whether dir is the top of a git checkout. .git is
+// a directory in a regular clone and a file in worktrees and submodules.
+func isRepositoryRoot(dir string) bool {
+	_, err := os.Stat(filepath.Join(dir, ".git"))
+	return err == nil
+}
+
+// isInsideNodeModules reports whether dir is in a node_modules tree, where
+// version files belong to installed packages rather than the project
+func isInsideNodeModules(dir string) bool {
+	for _, part := range strings.Split(filepath.ToSlash(dir), "/") {
+		if part == "node_modules" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// whichResult is the answer to 'nvu which', printed as text or as JSON
+type whichResult struct {
+	Command   string       `json:"command"`
+	Requested string       `json:"requested,omitempty"`
+	Version   string       `json:"version,omitempty"`
+	Dir       string       `json:"dir,omitempty"`
+	Source    *whichSource `json:"source,omitempty"`
+	Rule      string       `json:"rule,omitempty"`
+	Binary    string       `json:"binary,omitempty"`
+	Fallback  string       `json:"fallback,omitempty"`
+	Disabled  string       `json:"disabled,omitempty"`
+	Error     string       `json:"error,omitempty"`
+}
+
+// whichSource is the JSON form of a versionSource
+type whichSource struct {
+	Path  string `json:"path,omitempty"`
+	Line  int    `json:"line,omitempty"`
+	Field string `json:"field,omitempty"`
+	Env   string `json:"env,omitempty"`
+}
+
+// runWhich handles 'nvu which [--json] [--dir PATH] [command]': it reports
+// the version, installed directory, source and binary the shim for command
+// (default node) would use, using the same resolution as the shim itself
+func runWhich(args []string) {
+	asJSON := false
+	command := "node"
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--json":
+			asJSON = true
+		case arg == "--dir" && i+1 < len(args):
+			i++
+			startDirectory = args[i]
+		case strings.HasPrefix(arg, "--dir="):
+			startDirectory = strings.TrimPrefix(arg, "--dir=")
+		case strings.HasPrefix(arg, "-"):
+			fmt.Fprintf(os.Stderr, "nvu error: unknown option for which: %s\n", arg)
+			fmt.Fprintf(os.Stderr, "Usage: nvu which [--json] [--dir PATH] [command]\n")
+			os.Exit(1)
+		default:
+			command = strings.TrimSuffix(arg, ".exe")
+		}
+	}
+	if startDirectory != "" {
+		if abs, err := filepath.Abs(startDirectory); err == nil {
+			startDirectory = abs
+		}
+	}
+
+	result := resolveWhich(command)
+	if asJSON {
+		output, _ := json.MarshalIndent(result, "", "  ")
+		fmt.Println(string(output))
+	} else {
+		printWhich(result)
+	}
+	if result.Error != "" {
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+// resolveWhich resolves command the way the shim would, stopping at the
+// first step that fails
+func resolveWhich(command string) whichResult {
+	result := whichResult{Command: command}
+	if isRoutingDisabled() {
+		result.Disabled = "NVU_DISABLE"
+		resolveWhichSystem(&result)
+		return result
+	}
+
+	version, source, err := resolveVersionSource()
+	if source != (versionSource{}) {
+		result.Source = &whichSource{Path: source.path, Line: source.line, Field: source.field, Env: source.env}
+		result.Rule = source.rule()
+	}
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.Requested = version
+
+	if isDisabledVersion(version, source) {
+		result.Disabled = source.String()
+	}
+	if version == "system" || result.Disabled != "" {
+		resolveWhichSystem(&result)
+		return result
+	}
+
+	nvuHome, err := getNvuHome()
+	if err != nil {
+		result.Error = fmt.Sprintf("failed to get nvu home directory: %s", err)
+		return result
+	}
+	versionsDir := filepath.Join(nvuHome, "installed")
+	resolved, err := resolveInstalledVersion(versionsDir, version)
+	if err != nil {
+		result.Error = err.Error()
+		if !isReleaseIndexMissing(err) {
+			result.Error += fmt.Sprintf(" (run: nvu install %s)", version)
+		}
+		return result
+	}
+	result.Version = resolved
+	result.Dir = filepath.Join(versionsDir, resolved)
+
+	result.Binary, err = findBinary(command, version)
+	if err != nil && command != "node" && command != "npm" && command != "npx" {
+		// global tools fall back to the default version's, as in the shim
+		var fallback defaultBinary
+		if fallback, err = findDefaultBinary(command, version); err == nil {
+			result.Binary, result.Fallback = fallback.path, fallback.message
+			if getConfig().Strict {
+				result.Error = fallback.message + " (refused in strict mode)"
+			}
+		}
+	}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// resolveWhichSystem fills in the system binary for the command
+func resolveWhichSystem(result *whichResult) {
+	result.Binary = resolveSystemBinary(result.Command)
+	if result.Binary == "" {
+		result.Error = fmt.Sprintf("system %s not found", result.Command)
+	}
+}
+
+// printWhich prints the human-readable form of a which result
+func printWhich(result whichResult) {
+	if result.Disabled != "" {
+		fmt.Printf("Routing: disabled by %s, using the system %s\n", result.Disabled, result.Command)
+	}
+	switch {
+	case result.Version != "" && result.Version != result.Requested && result.Version != "v"+result.Requested:
+		fmt.Printf("Version: %s → %s\n", result.Requested, result.Version)
+	case result.Requested != "":
+		fmt.Printf("Version: %s\n", result.Requested)
+	}
+	if result.Source != nil {
+		source := versionSource{path: result.Source.Path, line: result.Source.Line, field: result.Source.Field, env: result.Source.Env}
+		fmt.Printf("Source: %s\n", source)
+		fmt.Printf("Rule: %s\n", result.Rule)
+	}
+	if result.Dir != "" {
+		fmt.Printf("Directory: %s\n", result.Dir)
+	}
+	if result.Binary != "" {
+		fmt.Printf("Binary: %s\n", result.Binary)
+	}
+	if result.Fallback != "" {
+		fmt.Printf("Fallback: %s\n", result.Fallback)
+	}
+	if result.Error != "" {
+		fmt.Fprintf(os.Stderr, "nvu error: %s\n", result.Error)
+	}
+}
+
+// rule names the resolution rule a source belongs to: the environment
+// variable, the global default, or the version files entry that matched
+// (e.g. ".nvmrc" or "package.json#volta.node")
+func (s versionSource) rule() string {
+	if s.env != "" {
+		return s.env
+	}
+	if isDefaultSource(s) {
+		return "default"
+	}
+	name := filepath.Base(s.path)
+	if s.field != "" {
+		return name + "#" + s.field
+	}
+	return name
+}
@@ -1,6 +1,7 @@
 import exit from 'exit-compat';
 import path from 'path';
 import { storagePath } from '../constants.ts';
+import cacheReleaseIndex from '../lib/cacheReleaseIndex.ts';
 import loadNodeVersionInstall from '../lib/loadNodeVersionInstall.ts';
 
 /**
@@ -59,7 +60,12 @@ export default function installCmd(args: string[]): void {
         } else {
           console.log(`Node ${version} installed successfully.`);
         }
-        exit(0);
+
+        // refresh the release index the binary uses to resolve lts/* aliases offline
+        cacheReleaseIndex((cacheErr) => {
+          if (cacheErr) console.error(`Warning: failed to cache the Node release index: ${cacheErr.message}`);
+          exit(0);
+        });
       }
     );
   });
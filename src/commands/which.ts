@@ -9,7 +9,8 @@ import { resolveSystemBinary } from '../lib/resolveSystemBinary.ts';
  * nvu which
  *
  * Show which Node binary would be used based on current directory.
- * This simulates what the nvu binary would do.
+ * The nvu binary answers `nvu which` itself with the shim's own resolution;
+ * this approximation only runs when the CLI is invoked without the binary.
  */
 export default function whichCmd(_args: string[]): void {
   const cwd = process.cwd();
@@ -0,0 +1,43 @@
+import fs from 'fs';
+import getFile from 'get-file-compat';
+import path from 'path';
+import { mkdirpSync } from '../compat.ts';
+import { storagePath } from '../constants.ts';
+
+const INDEX_URL = 'https://nodejs.org/dist/index.json';
+
+/**
+ * Refresh the cached Node release index (~/.nvu/cache/index.json).
+ * The binary reads it offline to resolve LTS aliases like lts/* and lts/iron.
+ * Failures are passed to the callback and never remove an existing cache.
+ */
+export default function cacheReleaseIndex(callback: (err?: Error | null) => void): void {
+  const cacheDir = path.join(storagePath, 'cache');
+  const indexPath = path.join(cacheDir, 'index.json');
+  const tempPath = `${indexPath}.${process.pid}.tmp`;
+
+  try {
+    mkdirpSync(cacheDir);
+  } catch (err) {
+    return callback(err as Error);
+  }
+
+  getFile(INDEX_URL, tempPath, (err?: Error | null) => {
+    if (!err) {
+      try {
+        // only replace the cache with a complete, parseable index
+        JSON.parse(fs.readFileSync(tempPath, 'utf8'));
+        fs.renameSync(tempPath, indexPath);
+        return callback();
+      } catch (parseErr) {
+        err = parseErr as Error;
+      }
+    }
+    try {
+      fs.unlinkSync(tempPath);
+    } catch (_e) {
+      // ignore cleanup errors
+    }
+    callback(err);
+  });
+}
@@ -5,6 +5,7 @@
  * Tests are skipped if binaries aren't built (Go not available).
  */
 import assert from 'assert';
+import { spawn as spawnChild } from 'child_process';
 import spawn from 'cross-spawn-cb';
 import fs from 'fs';
 import path from 'path';
@@ -16,6 +17,7 @@ const __dirname = path.dirname(typeof __filename !== 'undefined' ? __filename :
 const TMP_DIR = path.join(__dirname, '..', '..', '.tmp', 'binary-test');
 const isWindows = process.platform === 'win32' || /^(msys|cygwin)$/.test(process.env.OSTYPE ?? '');
 const NODE = isWindows ? 'node.exe' : 'node';
+const NVU = isWindows ? 'nvu.exe' : 'nvu';
 
 const OPTIONS = {
   encoding: 'utf8' as BufferEncoding,
@@ -26,8 +28,10 @@ const OPTIONS = {
   },
 };
 
-function createFakeNodeVersion(version: string): void {
-  const versionDir = path.join(TMP_DIR, 'installed', version);
+type ShimCallback = (err: (Error & { status?: number }) | null, stdout: string, stderr: string) => void;
+
+function createFakeNodeVersion(version: string, nvuHome: string = TMP_DIR): void {
+  const versionDir = path.join(nvuHome, 'installed', version);
 
   if (isWindows) {
     // On Windows, create a .cmd file at the root of the version directory
@@ -47,6 +51,72 @@ function createFakeNodeVersion(version: string): void {
   }
 }
 
+// Marks a fake version as an LTS release through the headers it ships with
+function markFakeLTSVersion(version: string, codename: string, nvuHome: string): void {
+  const includeDir = path.join(nvuHome, 'installed', version, 'include', 'node');
+  mkdirRecursive(includeDir);
+  fs.writeFileSync(path.join(includeDir, 'node_version.h'), `#define NODE_VERSION_IS_LTS 1\n#define NODE_VERSION_LTS_CODENAME "${codename}"\n`);
+}
+
+// Writes an executable shell script
+function writeScript(scriptPath: string, body: string): void {
+  mkdirRecursive(path.dirname(scriptPath));
+  fs.writeFileSync(scriptPath, `#!/bin/sh\n${body}\n`);
+  fs.chmodSync(scriptPath, 0o755);
+}
+
+let projects = 0;
+
+// Creates a fresh project directory under nvuHome holding the given files
+function createProject(nvuHome: string, files: { [name: string]: string } = {}): string {
+  const testDir = path.join(nvuHome, 'projects', String(projects++));
+  mkdirRecursive(testDir);
+  for (const name in files) {
+    mkdirRecursive(path.dirname(path.join(testDir, name)));
+    fs.writeFileSync(path.join(testDir, name), files[name]);
+  }
+  return testDir;
+}
+
+// Runs a shim from the test bin directory in cwd with an isolated NVU_HOME,
+// passing on its output whether or not it succeeded
+function runShim(name: string, args: string[], nvuHome: string, cwd: string, env: NodeJS.ProcessEnv, callback: ShimCallback): void {
+  const options = { ...OPTIONS, cwd, env: { ...OPTIONS.env, NVU_HOME: nvuHome, NVU_CEILING_DIRECTORIES: nvuHome, ...env } };
+  spawn(path.join(getTestBinaryBin(), name), args, options, (err, res) => {
+    const result = (err || res) as unknown as { stdout?: string; stderr?: string } | undefined;
+    callback(err || null, (result?.stdout || '').trim(), result?.stderr || '');
+  });
+}
+
+// Runs node --version through the shim
+function runNode(nvuHome: string, cwd: string, env: NodeJS.ProcessEnv, callback: ShimCallback): void {
+  runShim(NODE, ['--version'], nvuHome, cwd, env, callback);
+}
+
+// Runs node --version in a fresh project holding the given files
+function resolveProject(nvuHome: string, files: { [name: string]: string }, env: NodeJS.ProcessEnv, callback: ShimCallback): void {
+  runNode(nvuHome, createProject(nvuHome, files), env, callback);
+}
+
+// Returns a callback that expects node to print version
+function expectVersion(expected: string, done: (err?: Error) => void, message?: string): ShimCallback {
+  return (err, stdout, stderr) => {
+    if (err) return done(new Error(`${err.message}\n${stderr}`));
+    assert.equal(stdout, expected, message);
+    done();
+  };
+}
+
+// Returns a callback that expects the shim to fail with a message on stderr
+function expectFailure(expected: string, done: (err?: Error) => void, status?: number): ShimCallback {
+  return (err, stdout, stderr) => {
+    assert.ok(err, `the shim should fail, but printed ${stdout}`);
+    if (status !== undefined) assert.equal(err.status, status, stderr);
+    assert.ok(stderr.indexOf(expected) !== -1, stderr);
+    done();
+  };
+}
+
 describe('binary', () => {
   before(function () {
     if (!hasTestBinaries()) {
@@ -118,6 +188,1169 @@ describe('binary', () => {
     });
   });
 
+  describe('version selection', () => {
+    const nvuHome = path.join(TMP_DIR, 'selection');
+
+    before(() => {
+      for (const version of ['v16.20.2', 'v18.20.4', 'v20.1.5', 'v20.9.0', 'v20.19.6', 'v22.3.0', 'v23.0.0-rc.1']) createFakeNodeVersion(version, nvuHome);
+      markFakeLTSVersion('v18.20.4', 'hydrogen', nvuHome);
+      markFakeLTSVersion('v20.9.0', 'iron', nvuHome);
+    });
+
+    const cases = [
+      ['20', 'v20.19.6'],
+      ['20.9', 'v20.9.0'],
+      ['20.1', 'v20.1.5'],
+      ['v18.20.4', 'v18.20.4'],
+      ['^20', 'v20.19.6'],
+      ['~20.1', 'v20.1.5'],
+      ['18.x', 'v18.20.4'],
+      ['18 - 20', 'v20.19.6'],
+      ['16 || >=22', 'v22.3.0'],
+      ['<=20.9', 'v20.9.0'],
+      ['>=22', 'v22.3.0'],
+    ];
+    for (const [expression, expected] of cases) {
+      it(`resolves ${expression} to ${expected}`, (done) => {
+        resolveProject(nvuHome, { '.nvmrc': expression }, {}, expectVersion(expected, done));
+      });
+    }
+
+    it('orders versions numerically, not as strings', (done) => {
+      resolveProject(nvuHome, { '.nvmrc': '20' }, {}, expectVersion('v20.19.6', done, 'v20.19.6 is newer than v20.9.0'));
+    });
+
+    it('orders versions numerically in ranges', (done) => {
+      resolveProject(nvuHome, { '.nvmrc': '>=20.9 <21' }, {}, expectVersion('v20.19.6', done, 'v20.19.6 is newer than v20.9.0'));
+    });
+
+    it('reports a range that nothing installed satisfies', (done) => {
+      resolveProject(nvuHome, { '.nvmrc': '>=24' }, {}, expectFailure('>=24', done));
+    });
+
+    it('excludes prereleases unless asked for by full version', (done) => {
+      resolveProject(nvuHome, { '.nvmrc': '23' }, {}, (err, _stdout, stderr) => {
+        assert.ok(err, 'a major with only a prerelease installed should not resolve');
+        assert.ok(stderr.indexOf('23') !== -1, 'Binary should report the unresolved version');
+        resolveProject(nvuHome, { '.nvmrc': '23.0.0-rc.1' }, {}, expectVersion('v23.0.0-rc.1', done));
+      });
+    });
+
+    it('selects the lowest match with NVU_SELECT=lowest', (done) => {
+      resolveProject(nvuHome, { '.nvmrc': '20' }, { NVU_SELECT: 'lowest' }, expectVersion('v20.1.5', done));
+    });
+
+    it('selects the highest LTS match with NVU_SELECT=prefer-lts', (done) => {
+      resolveProject(nvuHome, { '.nvmrc': '20' }, { NVU_SELECT: 'prefer-lts' }, (err, stdout) => {
+        if (err) return done(err);
+        assert.equal(stdout, 'v20.9.0');
+        resolveProject(nvuHome, { '.nvmrc': '22' }, { NVU_SELECT: 'prefer-lts' }, expectVersion('v22.3.0', done, 'without an LTS match the highest version is used'));
+      });
+    });
+
+    it('applies the selection policy to ranges', (done) => {
+      resolveProject(nvuHome, { '.nvmrc': '>=18' }, { NVU_SELECT: 'prefer-lts' }, (err, stdout) => {
+        if (err) return done(err);
+        assert.equal(stdout, 'v20.9.0');
+        resolveProject(nvuHome, { '.nvmrc': '^20' }, { NVU_SELECT: 'lowest' }, expectVersion('v20.1.5', done));
+      });
+    });
+  });
+
+  describe('LTS aliases', () => {
+    const nvuHome = path.join(TMP_DIR, 'lts');
+    const index = [
+      { version: 'v22.3.0', lts: 'Jod' },
+      { version: 'v21.7.3', lts: false },
+      { version: 'v20.19.6', lts: 'Iron' },
+      { version: 'v18.20.4', lts: 'Hydrogen' },
+    ];
+
+    before(() => {
+      for (const version of ['v18.20.4', 'v20.9.0', 'v20.19.6', 'v21.7.3', 'v22.3.0']) createFakeNodeVersion(version, nvuHome);
+      mkdirRecursive(path.join(nvuHome, 'cache'));
+      fs.writeFileSync(path.join(nvuHome, 'cache', 'index.json'), JSON.stringify(index));
+    });
+
+    const cases = [
+      ['lts/*', 'v22.3.0'],
+      ['lts', 'v22.3.0'],
+      ['lts/iron', 'v20.19.6'],
+      ['lts/Hydrogen', 'v18.20.4'],
+      ['lts/-1', 'v20.19.6'],
+    ];
+    for (const [alias, expected] of cases) {
+      it(`resolves ${alias} to ${expected}`, (done) => {
+        resolveProject(nvuHome, { '.nvmrc': alias }, {}, expectVersion(expected, done));
+      });
+    }
+
+    it('classifies versions newer than the cache by their headers', (done) => {
+      const laterHome = path.join(TMP_DIR, 'lts-later');
+      for (const version of ['v22.3.0', 'v22.11.0']) createFakeNodeVersion(version, laterHome);
+      markFakeLTSVersion('v22.11.0', 'Jod', laterHome);
+      mkdirRecursive(path.join(laterHome, 'cache'));
+      fs.writeFileSync(path.join(laterHome, 'cache', 'index.json'), JSON.stringify(index));
+      resolveProject(laterHome, { '.nvmrc': 'lts/jod' }, {}, expectVersion('v22.11.0', done));
+    });
+
+    it('reports an unknown codename', (done) => {
+      resolveProject(nvuHome, { '.nvmrc': 'lts/argon' }, {}, expectFailure('unknown LTS codename in lts/argon', done));
+    });
+
+    it('reports a missing release index instead of guessing', (done) => {
+      const emptyHome = path.join(TMP_DIR, 'lts-uncached');
+      createFakeNodeVersion('v22.3.0', emptyHome);
+      resolveProject(emptyHome, { '.nvmrc': 'lts/*' }, {}, (err, stdout, stderr) => {
+        expectFailure('Node release index not cached', () => {})(err, stdout, stderr);
+        assert.ok(stderr.indexOf('may not be installed') === -1, 'only the refresh hint should be given');
+        done();
+      });
+    });
+  });
+
+  describe('version file names', () => {
+    const nvuHome = path.join(TMP_DIR, 'file-names');
+
+    before(() => {
+      createFakeNodeVersion('v20.19.6', nvuHome);
+      createFakeNodeVersion('v22.3.0', nvuHome);
+    });
+
+    it('reads .node-version', (done) => {
+      resolveProject(nvuHome, { '.node-version': '20.19.6\n' }, {}, expectVersion('v20.19.6', done));
+    });
+
+    it('prefers .nvmrc over .node-version by default', (done) => {
+      resolveProject(nvuHome, { '.nvmrc': '22\n', '.node-version': '20\n' }, {}, expectVersion('v22.3.0', done));
+    });
+
+    it('checks files in the order of NVU_VERSION_FILES', (done) => {
+      const env = { NVU_VERSION_FILES: '.node-version,.nvmrc' };
+      resolveProject(nvuHome, { '.nvmrc': '22\n', '.node-version': '20\n' }, env, expectVersion('v20.19.6', done));
+    });
+
+    it('ignores files left out of NVU_VERSION_FILES', (done) => {
+      const testDir = createProject(nvuHome, { '.nvmrc': '22\n' });
+      fs.writeFileSync(path.join(nvuHome, 'default'), '20\n');
+      runNode(nvuHome, testDir, { NVU_VERSION_FILES: '.node-version' }, (err, stdout) => {
+        fs.unlinkSync(path.join(nvuHome, 'default'));
+        if (err) return done(err);
+        assert.equal(stdout, 'v20.19.6', 'the default should be used');
+        done();
+      });
+    });
+  });
+
+  describe('.tool-versions', () => {
+    const nvuHome = path.join(TMP_DIR, 'tool-versions');
+
+    before(() => {
+      createFakeNodeVersion('v18.20.4', nvuHome);
+      createFakeNodeVersion('v20.19.6', nvuHome);
+    });
+
+    it('reads the nodejs line', (done) => {
+      resolveProject(nvuHome, { '.tool-versions': 'ruby 3.3.0\nnodejs 20.19.6 # pinned\npython 3.12.1\n' }, {}, expectVersion('v20.19.6', done));
+    });
+
+    it('accepts node as the tool name', (done) => {
+      resolveProject(nvuHome, { '.tool-versions': 'node 18.20.4\n' }, {}, expectVersion('v18.20.4', done));
+    });
+
+    it('uses later versions on the line as fallbacks', (done) => {
+      resolveProject(nvuHome, { '.tool-versions': 'nodejs 21.0.0 18.20.4\n' }, {}, expectVersion('v18.20.4', done));
+    });
+
+    it('skips ref: and path: versions', (done) => {
+      resolveProject(nvuHome, { '.tool-versions': 'nodejs ref:v20.0.0 path:/opt/node 20\n' }, {}, expectVersion('v20.19.6', done));
+    });
+
+    it('reports the first version when none is installed', (done) => {
+      resolveProject(nvuHome, { '.tool-versions': 'nodejs 21.0.0 19\n' }, {}, expectFailure('21.0.0', done));
+    });
+
+    it('rejects a version nvu does not understand', (done) => {
+      resolveProject(nvuHome, { '.tool-versions': 'ruby 3.3.0\nnodejs 20 20.1.x.1\n' }, {}, expectFailure('.tool-versions:2: invalid version "20.1.x.1"', done));
+    });
+  });
+
+  describe('package.json', () => {
+    const nvuHome = path.join(TMP_DIR, 'package-json');
+
+    before(() => {
+      for (const version of ['v18.20.4', 'v20.19.6', 'v22.3.0']) createFakeNodeVersion(version, nvuHome);
+      // every project sits below this one, so resolution that passes over
+      // package.json ends here
+      createProject(nvuHome);
+      fs.writeFileSync(path.join(nvuHome, 'projects', '.nvmrc'), '22\n');
+    });
+
+    // Returns a package.json with the given fields
+    function packageJSON(fields: object): string {
+      return JSON.stringify({ name: 'test', ...fields });
+    }
+
+    it('ignores engines.node unless opted in', (done) => {
+      resolveProject(nvuHome, { 'package.json': packageJSON({ engines: { node: '>=18 <21' } }) }, {}, expectVersion('v22.3.0', done));
+    });
+
+    it('reads engines.node with NVU_ENGINES', (done) => {
+      resolveProject(nvuHome, { 'package.json': packageJSON({ engines: { node: '>=18 <21' } }) }, { NVU_ENGINES: 'after' }, expectVersion('v20.19.6', done));
+    });
+
+    it('checks engines.node before or after the version files', (done) => {
+      const files = { 'package.json': packageJSON({ engines: { node: '^18' } }), '.nvmrc': '20\n' };
+      resolveProject(nvuHome, files, { NVU_ENGINES: 'after' }, (err, stdout) => {
+        if (err) return done(err);
+        assert.equal(stdout, 'v20.19.6');
+        resolveProject(nvuHome, files, { NVU_ENGINES: 'before' }, expectVersion('v18.20.4', done));
+      });
+    });
+
+    it('reads the volta.node pin', (done) => {
+      resolveProject(nvuHome, { 'package.json': packageJSON({ volta: { node: '20.19.6' } }) }, {}, expectVersion('v20.19.6', done));
+    });
+
+    it('follows volta.extends', (done) => {
+      const files = { 'shared.json': packageJSON({ volta: { node: '18.20.4' } }), 'app/package.json': packageJSON({ volta: { extends: '../shared.json' } }) };
+      runNode(nvuHome, path.join(createProject(nvuHome, files), 'app'), {}, expectVersion('v18.20.4', done));
+    });
+
+    it('names the field a missing version came from', (done) => {
+      resolveProject(nvuHome, { 'package.json': packageJSON({ volta: { node: '19.1.0' } }) }, {}, expectFailure('volta.node in', done));
+    });
+
+    it('reads devEngines.runtime', (done) => {
+      const devEngines = { runtime: { name: 'node', version: '^20' } };
+      resolveProject(nvuHome, { 'package.json': packageJSON({ devEngines }) }, {}, expectVersion('v20.19.6', done));
+    });
+
+    it('finds the node entry in a devEngines.runtime array', (done) => {
+      const devEngines = { runtime: [{ name: 'bun', version: '^1' }, { name: 'node', version: '^18' }] };
+      resolveProject(nvuHome, { 'package.json': packageJSON({ devEngines }) }, {}, expectVersion('v18.20.4', done));
+    });
+
+    it('tries the fallback policy before failing an unsatisfied devEngines.runtime', (done) => {
+      const devEngines = { runtime: { name: 'node', version: '^20.20' } };
+      resolveProject(nvuHome, { 'package.json': packageJSON({ devEngines }) }, { NVU_FALLBACK: 'nearest-higher' }, expectVersion('v22.3.0', done));
+    });
+
+    it('fails an unsatisfied devEngines.runtime by default', (done) => {
+      const devEngines = { runtime: { name: 'node', version: '^24' } };
+      resolveProject(nvuHome, { 'package.json': packageJSON({ devEngines }) }, {}, expectFailure('requires node ^24', done));
+    });
+
+    it('falls through for onFail warn and ignore', (done) => {
+      const devEngines = { runtime: { name: 'node', version: '^24', onFail: 'warn' } };
+      resolveProject(nvuHome, { 'package.json': packageJSON({ devEngines }) }, {}, (err, stdout, stderr) => {
+        if (err) return done(err);
+        assert.equal(stdout, 'v22.3.0', 'the parent .nvmrc should be used');
+        assert.ok(stderr.indexOf('nvu warning:') !== -1, stderr);
+        const ignored = { runtime: { ...devEngines.runtime, onFail: 'ignore' } };
+        resolveProject(nvuHome, { 'package.json': packageJSON({ devEngines: ignored }) }, {}, (err, stdout, stderr) => {
+          if (err) return done(err);
+          assert.equal(stdout, 'v22.3.0', 'the parent .nvmrc should be used');
+          assert.equal(stderr, '');
+          done();
+        });
+      });
+    });
+  });
+
+  describe('NVU_VERSION', () => {
+    const nvuHome = path.join(TMP_DIR, 'version-env');
+
+    before(() => {
+      createFakeNodeVersion('v20.19.6', nvuHome);
+      createFakeNodeVersion('v22.3.0', nvuHome);
+    });
+
+    it('beats every version file', (done) => {
+      resolveProject(nvuHome, { '.nvurc': '22\n', '.nvmrc': '22\n' }, { NVU_VERSION: '20' }, expectVersion('v20.19.6', done));
+    });
+
+    it('reports a version that is not installed', (done) => {
+      resolveProject(nvuHome, { '.nvmrc': '22\n' }, { NVU_VERSION: '19' }, expectFailure('NVU_VERSION', done));
+    });
+
+    it('accepts NODE_VERSION only when opted in', (done) => {
+      const files = { '.nvmrc': '22\n' };
+      resolveProject(nvuHome, files, { NODE_VERSION: '20' }, (err, stdout) => {
+        if (err) return done(err);
+        assert.equal(stdout, 'v22.3.0', 'NODE_VERSION should be ignored by default');
+        resolveProject(nvuHome, files, { NODE_VERSION: '20', NVU_NODE_VERSION_ENV: '1' }, expectVersion('v20.19.6', done));
+      });
+    });
+  });
+
+  describe('version file format', () => {
+    const nvuHome = path.join(TMP_DIR, 'format');
+
+    before(() => {
+      createFakeNodeVersion('v20.19.6', nvuHome);
+      createFakeNodeVersion('v22.3.0', nvuHome);
+    });
+
+    it('ignores a UTF-8 byte order mark', (done) => {
+      resolveProject(nvuHome, { '.nvmrc': '\uFEFF22\n' }, {}, expectVersion('v22.3.0', done));
+    });
+
+    it('accepts CRLF line endings', (done) => {
+      resolveProject(nvuHome, { '.nvmrc': '# pinned\r\n22\r\n' }, {}, expectVersion('v22.3.0', done));
+    });
+
+    it('ignores comment lines and trailing comments', (done) => {
+      resolveProject(nvuHome, { '.nvmrc': '# current LTS\n20 # iron\n' }, {}, expectVersion('v20.19.6', done));
+    });
+
+    it('rejects 20.1.x.1 with the line number', (done) => {
+      resolveProject(nvuHome, { '.nvmrc': '# pinned\n\n20.1.x.1\n' }, {}, (err, _stdout, stderr) => {
+        assert.ok(err, 'an invalid version should fail');
+        assert.ok(stderr.indexOf('.nvmrc:3') !== -1, 'Binary should report the line of the invalid version');
+        assert.ok(stderr.indexOf('invalid version "20.1.x.1"') !== -1, 'Binary should report the invalid version');
+        done();
+      });
+    });
+
+    it('does not fall back to the default after an invalid version', (done) => {
+      const testDir = createProject(nvuHome, { '.nvmrc': 'twenty\n' });
+      fs.writeFileSync(path.join(nvuHome, 'default'), '22\n');
+      runNode(nvuHome, testDir, {}, (err, _stdout, stderr) => {
+        fs.unlinkSync(path.join(nvuHome, 'default'));
+        assert.ok(err, 'an invalid version should fail');
+        assert.ok(stderr.indexOf('invalid version "twenty"') !== -1, stderr);
+        done();
+      });
+    });
+  });
+
+  describe('directory walk boundaries', () => {
+    const nvuHome = path.join(TMP_DIR, 'walk');
+
+    before(() => {
+      for (const version of ['v18.20.4', 'v20.19.6', 'v22.3.0']) createFakeNodeVersion(version, nvuHome);
+      fs.writeFileSync(path.join(nvuHome, 'default'), '22\n');
+    });
+
+    it('does not search ceiling directories', (done) => {
+      const testDir = createProject(nvuHome, { '.nvmrc': '20\n', 'app/index.js': '' });
+      runNode(nvuHome, path.join(testDir, 'app'), {}, (err, stdout) => {
+        if (err) return done(err);
+        assert.equal(stdout, 'v20.19.6');
+        runNode(nvuHome, path.join(testDir, 'app'), { NVU_CEILING_DIRECTORIES: testDir }, expectVersion('v22.3.0', done, 'the default should be used'));
+      });
+    });
+
+    it('stops at the git root with NVU_STOP_AT_GIT_ROOT', (done) => {
+      const testDir = createProject(nvuHome, { '.nvmrc': '20\n', 'repo/src/index.js': '' });
+      mkdirRecursive(path.join(testDir, 'repo', '.git'));
+      runNode(nvuHome, path.join(testDir, 'repo', 'src'), {}, (err, stdout) => {
+        if (err) return done(err);
+        assert.equal(stdout, 'v20.19.6');
+        runNode(nvuHome, path.join(testDir, 'repo', 'src'), { NVU_STOP_AT_GIT_ROOT: '1' }, expectVersion('v22.3.0', done, 'the default should be used'));
+      });
+    });
+
+    it('skips version files inside node_modules', (done) => {
+      const testDir = createProject(nvuHome, { '.nvmrc': '20\n', 'node_modules/dep/.nvmrc': '18\n' });
+      const depDir = path.join(testDir, 'node_modules', 'dep');
+      runNode(nvuHome, depDir, {}, (err, stdout) => {
+        if (err) return done(err);
+        assert.equal(stdout, 'v20.19.6', "the package's .nvmrc should be skipped");
+        runNode(nvuHome, depDir, { NVU_INCLUDE_NODE_MODULES: '1' }, expectVersion('v18.20.4', done));
+      });
+    });
+  });
+
+  describe('resolution cache', () => {
+    const nvuHome = path.join(TMP_DIR, 'cache');
+
+    before(() => {
+      createFakeNodeVersion('v20.19.6', nvuHome);
+      createFakeNodeVersion('v22.3.0', nvuHome);
+    });
+
+    // Runs node in cwd twice around a change, checking the version before and after
+    function expectChange(cwd: string, before: string, change: () => void, after: string, done: (err?: Error) => void, env: NodeJS.ProcessEnv = {}, changedEnv: NodeJS.ProcessEnv = env): void {
+      runNode(nvuHome, cwd, env, (err, stdout) => {
+        if (err) return done(err);
+        assert.equal(stdout, before);
+        assert.ok(fs.readdirSync(path.join(nvuHome, 'cache', 'resolve')).length > 0, 'the resolution should be cached');
+        change();
+        runNode(nvuHome, cwd, changedEnv, expectVersion(after, done));
+      });
+    }
+
+    it('notices an edited .nvmrc', (done) => {
+      const testDir = createProject(nvuHome, { '.nvmrc': '20\n' });
+      expectChange(testDir, 'v20.19.6', () => fs.writeFileSync(path.join(testDir, '.nvmrc'), '22.3\n'), 'v22.3.0', done);
+    });
+
+    it('notices a version file added in a parent directory', (done) => {
+      const testDir = createProject(nvuHome, { '.nvmrc': '20\n', 'packages/app/index.js': '' });
+      expectChange(path.join(testDir, 'packages', 'app'), 'v20.19.6', () => fs.writeFileSync(path.join(testDir, 'packages', '.nvmrc'), '22\n'), 'v22.3.0', done);
+    });
+
+    it('notices a newly installed version', (done) => {
+      const testDir = createProject(nvuHome, { '.nvmrc': '20\n' });
+      expectChange(testDir, 'v20.19.6', () => createFakeNodeVersion('v20.20.0', nvuHome), 'v20.20.0', done);
+    });
+
+    it('notices an edited alias', (done) => {
+      mkdirRecursive(path.join(nvuHome, 'aliases'));
+      fs.writeFileSync(path.join(nvuHome, 'aliases', 'work'), '20.19\n');
+      const testDir = createProject(nvuHome, { '.nvmrc': 'work\n' });
+      expectChange(testDir, 'v20.19.6', () => fs.writeFileSync(path.join(nvuHome, 'aliases', 'work'), '22.3\n'), 'v22.3.0', done);
+    });
+
+    it('does not cache a list that depends on the system node', (done) => {
+      const testDir = createProject(nvuHome, { '.nvmrc': 'system,22\n', 'empty/.keep': '' });
+      runNode(nvuHome, testDir, {}, (err, stdout) => {
+        if (err) return done(err);
+        assert.equal(stdout, process.version, 'the system node is first on PATH');
+        runNode(nvuHome, testDir, { PATH: path.join(testDir, 'empty') }, expectVersion('v22.3.0', done, 'without a system node the next entry is used'));
+      });
+    });
+  });
+
+  describe('nvu which', () => {
+    const nvuHome = path.join(TMP_DIR, 'which');
+
+    before(() => {
+      createFakeNodeVersion('v20.19.6', nvuHome);
+    });
+
+    it("falls back to the default version's global tools as the shim does", function (done) {
+      // the tool is a shell script
+      if (isWindows) return this.skip();
+      const fallbackHome = path.join(TMP_DIR, 'which-fallback');
+      createFakeNodeVersion('v20.19.6', fallbackHome);
+      createFakeNodeVersion('v22.3.0', fallbackHome);
+      fs.writeFileSync(path.join(fallbackHome, 'default'), '22\n');
+      const tscPath = path.join(fallbackHome, 'installed', 'v22.3.0', 'bin', 'tsc');
+      writeScript(tscPath, 'echo tsc');
+      const testDir = createProject(fallbackHome, { '.nvmrc': '20\n' });
+      runShim(NVU, ['which', '--json', 'tsc'], fallbackHome, testDir, {}, (err, stdout, stderr) => {
+        if (err) return done(new Error(`${err.message}\n${stderr}`));
+        const result = JSON.parse(stdout);
+        assert.equal(result.version, 'v20.19.6');
+        assert.equal(result.binary, tscPath);
+        assert.ok(result.fallback.indexOf('using the one from default Node v22.3.0') !== -1, result.fallback);
+        done();
+      });
+    });
+
+    it('prints the version, source and binary as JSON', (done) => {
+      const testDir = createProject(nvuHome, { '.nvmrc': '20\n' });
+      runShim(NVU, ['which', '--json'], nvuHome, testDir, {}, (err, stdout) => {
+        if (err) return done(err);
+        const result = JSON.parse(stdout);
+        assert.equal(result.command, 'node');
+        assert.equal(result.requested, '20');
+        assert.equal(result.version, 'v20.19.6');
+        assert.equal(result.dir, path.join(nvuHome, 'installed', 'v20.19.6'));
+        assert.deepEqual(result.source, { path: path.join(testDir, '.nvmrc'), line: 1 });
+        assert.equal(result.rule, '.nvmrc');
+        assert.ok(result.binary.indexOf(path.join(nvuHome, 'installed', 'v20.19.6')) === 0, result.binary);
+        done();
+      });
+    });
+
+    it('prints the same fields as text', (done) => {
+      const testDir = createProject(nvuHome, { '.nvmrc': '20\n' });
+      runShim(NVU, ['which'], nvuHome, testDir, {}, (err, stdout) => {
+        if (err) return done(err);
+        assert.ok(stdout.indexOf('Version: 20 → v20.19.6') !== -1, stdout);
+        assert.ok(stdout.indexOf(`Source: ${path.join(testDir, '.nvmrc')}:1`) !== -1, stdout);
+        assert.ok(stdout.indexOf('Rule: .nvmrc') !== -1, stdout);
+        assert.ok(stdout.indexOf(`Directory: ${path.join(nvuHome, 'installed', 'v20.19.6')}`) !== -1, stdout);
+        done();
+      });
+    });
+
+    it('resolves from another directory with --dir', (done) => {
+      const testDir = createProject(nvuHome, { '.nvmrc': '20\n' });
+      runShim(NVU, ['which', '--json', '--dir', testDir], nvuHome, nvuHome, {}, (err, stdout) => {
+        if (err) return done(err);
+        assert.equal(JSON.parse(stdout).version, 'v20.19.6');
+        done();
+      });
+    });
+
+    it('reports an environment source and its rule', (done) => {
+      runShim(NVU, ['which', '--json'], nvuHome, createProject(nvuHome), { NVU_VERSION: '20' }, (err, stdout) => {
+        if (err) return done(err);
+        const result = JSON.parse(stdout);
+        assert.deepEqual(result.source, { env: 'NVU_VERSION' });
+        assert.equal(result.rule, 'NVU_VERSION');
+        done();
+      });
+    });
+
+    it('reports a version that is not installed with an error field', (done) => {
+      const testDir = createProject(nvuHome, { '.nvmrc': '19\n' });
+      runShim(NVU, ['which', '--json'], nvuHome, testDir, {}, (err, stdout) => {
+        assert.ok(err, 'which should fail');
+        const result = JSON.parse(stdout);
+        assert.equal(result.requested, '19');
+        assert.ok(result.error.indexOf('nvu install 19') !== -1, result.error);
+        done();
+      });
+    });
+  });
+
+  describe('strict mode', () => {
+    const nvuHome = path.join(TMP_DIR, 'strict');
+    const tscPath = path.join(nvuHome, 'bin', 'tsc');
+
+    before(function () {
+      // the tools are shell scripts
+      if (isWindows) return this.skip();
+      createFakeNodeVersion('v20.19.6', nvuHome);
+      createFakeNodeVersion('v22.3.0', nvuHome);
+      writeScript(path.join(nvuHome, 'installed', 'v20.19.6', 'bin', 'tsc'), 'echo "tsc from v20.19.6"');
+      mkdirRecursive(path.dirname(tscPath));
+      fs.copyFileSync(path.join(getTestBinaryBin(), NODE), tscPath);
+      fs.chmodSync(tscPath, 0o755);
+    });
+
+    // Runs the tsc shim in cwd
+    function runTsc(cwd: string, env: NodeJS.ProcessEnv, callback: ShimCallback): void {
+      const options = { ...OPTIONS, cwd, env: { ...OPTIONS.env, NVU_HOME: nvuHome, NVU_CEILING_DIRECTORIES: nvuHome, ...env } };
+      spawn(tscPath, [], options, (err, res) => {
+        const result = (err || res) as unknown as { stdout?: string; stderr?: string } | undefined;
+        callback(err || null, (result?.stdout || '').trim(), result?.stderr || '');
+      });
+    }
+
+    it('refuses the system binary without a version with exit code 64', (done) => {
+      resolveProject(nvuHome, {}, { NVU_STRICT: '1' }, expectFailure('refused in strict mode', done, 64));
+    });
+
+    it("refuses the system copy of a tool with exit code 65", (done) => {
+      const systemDir = path.join(nvuHome, 'system');
+      writeScript(path.join(systemDir, 'tsc'), 'echo "system tsc"');
+      const testDir = createProject(nvuHome, { '.nvmrc': '22\n' });
+      const env = { PATH: `${systemDir}${path.delimiter}${OPTIONS.env.PATH}` };
+      runTsc(testDir, env, (err, stdout) => {
+        if (err) return done(err);
+        assert.equal(stdout, 'system tsc', 'outside strict mode the system tsc runs');
+        runTsc(testDir, { ...env, NVU_STRICT: '1' }, expectFailure('tsc is not installed for Node 22', done, 65));
+      });
+    });
+
+    it("refuses the default version's copy of a tool with exit code 66", (done) => {
+      fs.writeFileSync(path.join(nvuHome, 'default'), '20\n');
+      const testDir = createProject(nvuHome, { '.nvmrc': '22\n' });
+      runTsc(testDir, { NVU_STRICT: '1' }, (err, _stdout, stderr) => {
+        fs.unlinkSync(path.join(nvuHome, 'default'));
+        expectFailure('using the one from default Node v20.19.6', done, 66)(err, '', stderr);
+      });
+    });
+
+    it('warns about a fallback with NVU_WARN_FALLBACK', (done) => {
+      fs.writeFileSync(path.join(nvuHome, 'default'), '20\n');
+      const testDir = createProject(nvuHome, { '.nvmrc': '22\n' });
+      runTsc(testDir, { NVU_WARN_FALLBACK: '1' }, (err, stdout, stderr) => {
+        fs.unlinkSync(path.join(nvuHome, 'default'));
+        if (err) return done(err);
+        assert.equal(stdout, 'tsc from v20.19.6');
+        assert.ok(stderr.indexOf('nvu warning: tsc is not installed for Node 22') !== -1, stderr);
+        done();
+      });
+    });
+  });
+
+  describe('auto-install', () => {
+    let homes = 0;
+
+    before(function () {
+      // the fake system node and its installer are shell scripts
+      if (isWindows) return this.skip();
+    });
+
+    // Creates an NVU_HOME whose default is a fake system node with the nvu
+    // CLI, which "installs" v24.0.0 after running the install script, and a
+    // project asking for 24. The shims come first on PATH and the system npm
+    // is a #!/usr/bin/env node script, as in a real setup.
+    function createAutoInstallHome(install = '', files: { [name: string]: string } = { '.nvmrc': '24\n' }): { nvuHome: string; testDir: string; env: NodeJS.ProcessEnv } {
+      const nvuHome = path.join(TMP_DIR, 'auto-install', String(homes++));
+      const systemDir = path.join(nvuHome, 'system');
+      const globalRoot = path.join(systemDir, 'lib', 'node_modules');
+      const binDir = path.join(nvuHome, 'installed', 'v24.0.0', 'bin');
+      const installs = path.join(nvuHome, 'installs');
+      writeScript(
+        path.join(systemDir, 'node'),
+        `case "$2" in\nroot) echo "${globalRoot}" ;;\ninstall) echo "$3" >> "${installs}"\n${install}\nmkdir -p "${binDir}" && printf '#!/bin/sh\\necho v24.0.0\\n' > "${binDir}/node" && chmod +x "${binDir}/node" ;;\nesac`
+      );
+      fs.writeFileSync(path.join(systemDir, 'npm'), '#!/usr/bin/env node\n');
+      fs.chmodSync(path.join(systemDir, 'npm'), 0o755);
+      mkdirRecursive(path.join(globalRoot, 'node-version-use', 'bin'));
+      fs.writeFileSync(path.join(globalRoot, 'node-version-use', 'bin', 'cli.js'), '');
+      fs.writeFileSync(path.join(nvuHome, 'default'), 'system\n');
+
+      const testDir = createProject(nvuHome, files);
+      const env = { PATH: [getTestBinaryBin(), systemDir, '/usr/bin', '/bin'].join(path.delimiter), NVU_AUTO_INSTALL: '1', CI: '' };
+      return { nvuHome, testDir, env };
+    }
+
+    // Returns the versions the fake CLI was asked to install
+    function readInstalls(nvuHome: string): string[] {
+      const installs = path.join(nvuHome, 'installs');
+      return fs.existsSync(installs) ? fs.readFileSync(installs, 'utf8').trim().split('\n') : [];
+    }
+
+    // Runs node --version through the shim on a terminal, giving answer to
+    // the install prompt
+    function runOnTerminal(nvuHome: string, testDir: string, env: NodeJS.ProcessEnv, answer: string, callback: (status: number | null, output: string) => void): void {
+      const command = `"${path.join(getTestBinaryBin(), NODE)}" --version`;
+      const options = { cwd: testDir, env: { ...OPTIONS.env, NVU_HOME: nvuHome, NVU_CEILING_DIRECTORIES: nvuHome, ...env } };
+      const child = spawnChild('script', ['-qec', command, '/dev/null'], options);
+      let output = '';
+      child.stdout.on('data', (data) => {
+        output += data;
+        if (output.indexOf('[Y/n]') !== -1 && answer) {
+          child.stdin.write(`${answer}\n`);
+          answer = '';
+        }
+      });
+      child.on('close', (status) => callback(status, output));
+    }
+
+    it('installs with the system CLI without asking when CI is set', (done) => {
+      const { nvuHome, testDir, env } = createAutoInstallHome();
+      runNode(nvuHome, testDir, { ...env, CI: '1' }, (err, stdout, stderr) => {
+        if (err) return done(new Error(`${err.message}\n${stderr}`));
+        assert.equal(stdout, 'v24.0.0');
+        assert.ok(stderr.indexOf(`nvu: installing Node 24 (from ${path.join(testDir, '.nvmrc')}:1)`) !== -1, stderr);
+        assert.deepEqual(readInstalls(nvuHome), ['24']);
+        done();
+      });
+    });
+
+    it('installs for devEngines.runtime before its onFail applies', (done) => {
+      const devEngines = { runtime: { name: 'node', version: '^24', onFail: 'error' } };
+      const { nvuHome, testDir, env } = createAutoInstallHome('', { 'package.json': JSON.stringify({ name: 'test', devEngines }) });
+      runNode(nvuHome, testDir, { ...env, CI: '1' }, (err, stdout, stderr) => {
+        if (err) return done(new Error(`${err.message}\n${stderr}`));
+        assert.equal(stdout, 'v24.0.0');
+        assert.deepEqual(readInstalls(nvuHome), ['^24']);
+        done();
+      });
+    });
+
+    it('does not install without a terminal to confirm on', (done) => {
+      const { nvuHome, testDir, env } = createAutoInstallHome();
+      runNode(nvuHome, testDir, env, (err, stdout, stderr) => {
+        expectFailure('not installing Node 24 automatically without a terminal', () => {}, 1)(err, stdout, stderr);
+        assert.deepEqual(readInstalls(nvuHome), []);
+        done();
+      });
+    });
+
+    it('asks on a terminal and installs when told to', function (done) {
+      // util-linux script provides the terminal
+      if (process.platform !== 'linux') return this.skip();
+      const { nvuHome, testDir, env } = createAutoInstallHome();
+      runOnTerminal(nvuHome, testDir, env, 'y', (status, output) => {
+        assert.equal(status, 0, output);
+        assert.ok(output.indexOf(`Node 24 (from ${path.join(testDir, '.nvmrc')}:1) is not installed. Install it now? [Y/n]`) !== -1, output);
+        assert.ok(output.indexOf('v24.0.0') !== -1, output);
+        assert.deepEqual(readInstalls(nvuHome), ['24']);
+        done();
+      });
+    });
+
+    it('reports the missing version when the install is declined', function (done) {
+      // util-linux script provides the terminal
+      if (process.platform !== 'linux') return this.skip();
+      const { nvuHome, testDir, env } = createAutoInstallHome();
+      runOnTerminal(nvuHome, testDir, env, 'n', (status, output) => {
+        assert.equal(status, 1, output);
+        assert.ok(output.indexOf('Node 24 (from') !== -1, output);
+        assert.ok(output.indexOf('may not be installed. Run: nvu install 24') !== -1, output);
+        assert.deepEqual(readInstalls(nvuHome), []);
+        done();
+      });
+    });
+
+    it('waits for another install of the version instead of installing it again', (done) => {
+      const { nvuHome, testDir, env } = createAutoInstallHome('sleep 1');
+      const results: string[][] = [];
+      const collect = (err: Error | null, stdout: string, stderr: string) => {
+        if (err) return done(new Error(`${err.message}\n${stderr}`));
+        results.push([stdout, stderr]);
+        if (results.length < 2) return;
+        assert.deepEqual(
+          results.map(([stdout]) => stdout),
+          ['v24.0.0', 'v24.0.0']
+        );
+        const waited = results.filter(([, stderr]) => stderr.indexOf(`nvu: waiting for another install to finish (${path.join(nvuHome, 'install.lock')})`) !== -1);
+        assert.equal(waited.length, 1, results.map(([, stderr]) => stderr).join('\n'));
+        assert.deepEqual(readInstalls(nvuHome), ['24']);
+        done();
+      };
+      runNode(nvuHome, testDir, { ...env, CI: '1' }, collect);
+      runNode(nvuHome, testDir, { ...env, CI: '1' }, collect);
+    });
+
+    it('fails instead of waiting on a lock held by its own ancestor', (done) => {
+      const nested = createProject(path.join(TMP_DIR, 'auto-install'), { '.nvmrc': '25\n' });
+      const { nvuHome, testDir, env } = createAutoInstallHome(`(cd "${nested}" && NVU_AUTO_INSTALL=1 "${path.join(getTestBinaryBin(), NODE)}" --version)`);
+      runNode(nvuHome, testDir, { ...env, CI: '1' }, (err, stdout, stderr) => {
+        if (err) return done(new Error(`${err.message}\n${stderr}`));
+        assert.equal(stdout, 'v24.0.0');
+        assert.ok(stderr.indexOf('which started this one, so it would never be released') !== -1, stderr);
+        done();
+      });
+    });
+  });
+
+  describe('resolving from the entry script', () => {
+    const nvuHome = path.join(TMP_DIR, 'entry-script');
+    let cwd: string;
+    let script: string;
+
+    before(() => {
+      createFakeNodeVersion('v20.19.6', nvuHome);
+      createFakeNodeVersion('v22.3.0', nvuHome);
+      cwd = createProject(nvuHome, { '.nvmrc': '22\n' });
+      script = path.join(createProject(nvuHome, { '.nvmrc': '20\n', 'scripts/migrate.js': '' }), 'scripts', 'migrate.js');
+    });
+
+    const cases: [string, () => string[], string][] = [
+      ['a script', () => [script], 'v20.19.6'],
+      ['a script after --require x', () => ['--require', 'x', script], 'v20.19.6'],
+      ['a script after --require=x and -r x', () => ['--require=x', '-r', 'x', script], 'v20.19.6'],
+      ['a script after --', () => ['--no-warnings', '--', script], 'v20.19.6'],
+      ['-e', () => ['-e', script], 'v22.3.0'],
+      ['-pe', () => ['-pe', script], 'v22.3.0'],
+      ['a missing script', () => [path.join(path.dirname(script), 'missing', 'x.js')], 'v22.3.0'],
+      ['--', () => ['--'], 'v22.3.0'],
+    ];
+    for (const [name, args, expected] of cases) {
+      it(`resolves ${name} to ${expected}`, (done) => {
+        runShim(NODE, args(), nvuHome, cwd, { NVU_RESOLVE_FROM: 'script' }, expectVersion(expected, done));
+      });
+    }
+
+    it('resolves from the current directory by default', (done) => {
+      runShim(NODE, [script], nvuHome, cwd, {}, expectVersion('v22.3.0', done));
+    });
+  });
+
+  describe('fallback policy', () => {
+    const nvuHome = path.join(TMP_DIR, 'fallback');
+
+    before(() => {
+      for (const version of ['v20.1.5', 'v20.19.6', 'v22.3.0']) createFakeNodeVersion(version, nvuHome);
+      fs.writeFileSync(path.join(nvuHome, 'default'), '22\n');
+    });
+
+    it('fails by default', (done) => {
+      resolveProject(nvuHome, { '.nvmrc': '20.11.0\n' }, {}, expectFailure('20.11.0', done));
+    });
+
+    const cases = [
+      ['same-major', '20.11.0', 'v20.19.6'],
+      ['same-minor', '20.1.0', 'v20.1.5'],
+      ['nearest-higher', '21', 'v22.3.0'],
+      ['default', '18', 'v22.3.0'],
+    ];
+    for (const [policy, version, expected] of cases) {
+      it(`uses ${expected} for ${version} with ${policy}`, (done) => {
+        resolveProject(nvuHome, { '.nvmrc': `${version}\n` }, { NVU_FALLBACK: policy }, (err, stdout, stderr) => {
+          if (err) return done(err);
+          assert.equal(stdout, expected);
+          assert.ok(stderr.indexOf(`nvu note: Node ${version} (from ${path.join(nvuHome, 'projects')}`) === 0, stderr);
+          assert.ok(stderr.indexOf(`(fallback: ${policy})`) !== -1, stderr);
+          done();
+        });
+      });
+    }
+
+    it('fails when the policy has no substitute', (done) => {
+      resolveProject(nvuHome, { '.nvmrc': '20.11.0\n' }, { NVU_FALLBACK: 'same-minor' }, expectFailure('20.11.0', done));
+    });
+
+    it("reads the project's policy from .nvurc", (done) => {
+      resolveProject(nvuHome, { '.nvurc': 'fallback = same-major\n', '.nvmrc': '20.11.0\n' }, {}, expectVersion('v20.19.6', done));
+    });
+
+    it('lets NVU_FALLBACK override the project', (done) => {
+      resolveProject(nvuHome, { '.nvurc': 'fallback = same-major\n', '.nvmrc': '20.11.0\n' }, { NVU_FALLBACK: 'fail' }, expectFailure('20.11.0', done));
+    });
+
+    it('lets the project override config.json', (done) => {
+      const configHome = path.join(TMP_DIR, 'fallback-config');
+      for (const version of ['v20.1.5', 'v20.19.6']) createFakeNodeVersion(version, configHome);
+      fs.writeFileSync(path.join(configHome, 'config.json'), JSON.stringify({ fallback: 'same-major' }));
+      resolveProject(configHome, { '.nvmrc': '20.1.0\n' }, {}, (err, stdout, stderr) => {
+        if (err) return done(new Error(`${err.message}\n${stderr}`));
+        assert.equal(stdout, 'v20.19.6');
+        resolveProject(configHome, { '.nvurc': 'fallback = same-minor\n', '.nvmrc': '20.1.0\n' }, {}, expectVersion('v20.1.5', done));
+      });
+    });
+  });
+
+  describe('disabling nvu', () => {
+    const nvuHome = path.join(TMP_DIR, 'disable');
+
+    before(() => {
+      createFakeNodeVersion('v20.19.6', nvuHome);
+    });
+
+    it('runs the system node with NVU_DISABLE=1', (done) => {
+      resolveProject(nvuHome, { '.nvmrc': '20\n' }, { NVU_DISABLE: '1' }, expectVersion(process.version, done));
+    });
+
+    it('runs the system node below an .nvurc saying off', (done) => {
+      const testDir = createProject(nvuHome, { '.nvurc': 'off\n', 'vendor/index.js': '' });
+      runNode(nvuHome, path.join(testDir, 'vendor'), {}, expectVersion(process.version, done));
+    });
+
+    it('routes again below a nearer version file', (done) => {
+      const testDir = createProject(nvuHome, { '.nvurc': 'off\n', 'app/.nvmrc': '20\n' });
+      runNode(nvuHome, path.join(testDir, 'app'), {}, expectVersion('v20.19.6', done));
+    });
+
+    it('only accepts off in .nvurc', (done) => {
+      resolveProject(nvuHome, { '.nvmrc': 'off\n' }, {}, expectFailure('.nvmrc:1: invalid version "off"', done));
+    });
+
+    it('reads settings lines only in .nvurc', (done) => {
+      resolveProject(nvuHome, { '.nvmrc': 'fallback = same-major\n20\n' }, {}, expectFailure('.nvmrc:1: invalid version', done));
+    });
+
+    it('reports routing as disabled in nvu which', (done) => {
+      runShim(NVU, ['which', '--json'], nvuHome, createProject(nvuHome, { '.nvmrc': '20\n' }), { NVU_DISABLE: '1' }, (err, stdout) => {
+        if (err) return done(err);
+        const result = JSON.parse(stdout);
+        assert.equal(result.disabled, 'NVU_DISABLE');
+        assert.equal(result.binary, process.execPath);
+        done();
+      });
+    });
+  });
+
+  describe('pinning', () => {
+    const nvuHome = path.join(TMP_DIR, 'pin');
+    const pinEnv = { NVU_RESOLVED_VERSION: 'v20.19.6', NVU_RESOLVED_DIR: path.join(nvuHome, 'installed', 'v20.19.6') };
+
+    before(() => {
+      createFakeNodeVersion('v20.19.6', nvuHome);
+      createFakeNodeVersion('v22.3.0', nvuHome);
+    });
+
+    it('pins the resolved version for child processes', function (done) {
+      // the fake node is a shell script that prints its environment
+      if (isWindows) return this.skip();
+      const pinHome = path.join(TMP_DIR, 'pin-child');
+      createFakeNodeVersion('v22.3.0', pinHome);
+      writeScript(path.join(pinHome, 'installed', 'v22.3.0', 'bin', 'node'), 'echo "$NVU_RESOLVED_VERSION $NVU_RESOLVED_DIR ${PATH%%:*}"');
+      resolveProject(pinHome, { '.nvmrc': '22\n' }, {}, (err, stdout) => {
+        if (err) return done(err);
+        const dir = path.join(pinHome, 'installed', 'v22.3.0');
+        assert.equal(stdout, `v22.3.0 ${dir} ${path.join(dir, 'bin')}`);
+        done();
+      });
+    });
+
+    it('uses an inherited pin instead of the version files', (done) => {
+      resolveProject(nvuHome, { '.nvmrc': '22\n' }, pinEnv, expectVersion('v20.19.6', done));
+    });
+
+    it('ignores a pin from another NVU_HOME', (done) => {
+      const env = { NVU_RESOLVED_VERSION: 'v20.19.6', NVU_RESOLVED_DIR: path.join(TMP_DIR, 'elsewhere', 'installed', 'v20.19.6') };
+      resolveProject(nvuHome, { '.nvmrc': '22\n' }, env, expectVersion('v22.3.0', done));
+    });
+
+    it('ignores the pin with NVU_PIN=0', (done) => {
+      resolveProject(nvuHome, { '.nvmrc': '22\n' }, { ...pinEnv, NVU_PIN: '0' }, expectVersion('v22.3.0', done));
+    });
+
+    it('lets NVU_VERSION override the pin', (done) => {
+      resolveProject(nvuHome, { '.nvmrc': '20\n' }, { ...pinEnv, NVU_VERSION: '22' }, expectVersion('v22.3.0', done));
+    });
+  });
+
+  describe('supervised commands', () => {
+    const nvuHome = path.join(TMP_DIR, 'supervise');
+    const npmPath = path.join(nvuHome, 'installed', 'v22.3.0', 'bin', 'npm');
+
+    before(function () {
+      // the fake npm is a shell script, and Windows has no signals to forward
+      if (isWindows) return this.skip();
+      createFakeNodeVersion('v22.3.0', nvuHome);
+    });
+
+    // Starts npm install -g through the shim, which supervises npm so it can
+    // create shims afterwards. A detached shim leads a process group of its own.
+    function startGlobalInstall(script: string, callback: (status: number | null, stdout: string, stderr: string) => void, detached = false) {
+      writeScript(npmPath, script);
+      const testDir = createProject(nvuHome, { '.nvmrc': '22\n' });
+      // the script needs sleep from the system PATH
+      const env = { ...OPTIONS.env, PATH: `${OPTIONS.env.PATH}${path.delimiter}${process.env.PATH}`, NVU_HOME: nvuHome, NVU_CEILING_DIRECTORIES: nvuHome };
+      const child = spawnChild(path.join(getTestBinaryBin(), 'npm'), ['install', '-g', 'nothing'], { cwd: testDir, env, stdio: ['ignore', 'pipe', 'pipe'], detached });
+      let stdout = '';
+      let stderr = '';
+      child.stdout.on('data', (data) => {
+        stdout += data;
+      });
+      child.stderr.on('data', (data) => {
+        stderr += data;
+      });
+      child.on('close', (status) => callback(status, stdout, stderr));
+      return child;
+    }
+
+    it('forwards SIGTERM to the command and exits with its code', (done) => {
+      const child = startGlobalInstall('[ "$1" = install ] || exit 0\ntrap \'echo "npm got TERM" >&2; exit 7\' TERM\necho ready\nwhile :; do sleep 1; done', (status, _stdout, stderr) => {
+        assert.equal(status, 7, stderr);
+        assert.ok(stderr.indexOf('npm got TERM') !== -1, stderr);
+        done();
+      });
+      child.stdout.once('data', () => child.kill('SIGTERM'));
+    });
+
+    it('keeps the command in its process group without a terminal', (done) => {
+      const pidFile = path.join(nvuHome, 'npm.pid');
+      const child = startGlobalInstall(
+        `[ "$1" = install ] || exit 0\necho $$ > "${pidFile}"\necho ready\nwhile :; do sleep 1; done`,
+        () => {
+          const pid = Number(fs.readFileSync(pidFile, 'utf8'));
+          setTimeout(() => {
+            // an orphan may linger as a zombie until init reaps it
+            const stat = path.join('/proc', String(pid), 'stat');
+            let alive = true;
+            try {
+              process.kill(pid, 0);
+              if (fs.existsSync(stat) && fs.readFileSync(stat, 'utf8').split(') ')[1][0] === 'Z') alive = false;
+              else process.kill(pid, 'SIGKILL');
+            } catch (_err) {
+              alive = false;
+            }
+            assert.ok(!alive, 'killing the group should kill npm too');
+            done();
+          }, 100);
+        },
+        true
+      );
+      child.stdout.once('data', () => process.kill(-child.pid, 'SIGKILL'));
+    });
+
+    it('exits with 128+n when the command dies from signal n', (done) => {
+      startGlobalInstall('[ "$1" = install ] || exit 0\nkill -TERM $$', (status, _stdout, stderr) => {
+        assert.equal(status, 143, stderr);
+        done();
+      });
+    });
+  });
+
+  describe('hooks', () => {
+    let homes = 0;
+
+    before(function () {
+      // the hooks are shell scripts
+      if (isWindows) this.skip();
+    });
+
+    // Creates an NVU_HOME with a node that exits with NVU_TEST_EXIT and the
+    // given hooks, and returns a project directory in it
+    function createHookProject(hooks: { [name: string]: string }): { nvuHome: string; testDir: string } {
+      const nvuHome = path.join(TMP_DIR, 'hooks', String(homes++));
+      createFakeNodeVersion('v22.3.0', nvuHome);
+      writeScript(path.join(nvuHome, 'installed', 'v22.3.0', 'bin', 'node'), 'echo v22.3.0\nexit ${NVU_TEST_EXIT:-0}');
+      for (const name in hooks) writeScript(path.join(nvuHome, 'hooks', name), hooks[name]);
+      return { nvuHome, testDir: createProject(nvuHome, { '.nvmrc': '22\n' }) };
+    }
+
+    it('aborts the command when a pre-exec hook fails', (done) => {
+      const { nvuHome, testDir } = createHookProject({ 'pre-exec': 'echo "checking $NVU_HOOK_COMMAND $NVU_HOOK_VERSION"\nexit 3' });
+      runNode(nvuHome, testDir, {}, (err, stdout, stderr) => {
+        assert.ok(err, 'the command should fail');
+        assert.equal(stdout, '', 'node should not run');
+        assert.ok(stderr.indexOf('checking node v22.3.0') !== -1, 'hook output goes to stderr');
+        assert.ok(stderr.indexOf('exited with code 3, not running node') !== -1, stderr);
+        done();
+      });
+    });
+
+    it('exits with 128+n when a pre-exec hook dies from signal n', (done) => {
+      const { nvuHome, testDir } = createHookProject({ 'pre-exec': 'kill -TERM $$' });
+      runNode(nvuHome, testDir, {}, expectFailure('exited with code 143, not running node', done, 143));
+    });
+
+    it('passes the exit code to post-exec hooks in NVU_HOOK_EXIT_CODE', (done) => {
+      const { nvuHome, testDir } = createHookProject({ 'post-exec': 'echo "post-exec saw $NVU_HOOK_EXIT_CODE"' });
+      runNode(nvuHome, testDir, { NVU_TEST_EXIT: '5' }, (err, stdout, stderr) => {
+        assert.ok(err, 'the exit code of node should be kept');
+        assert.equal(stdout, 'v22.3.0');
+        assert.ok(stderr.indexOf('post-exec saw 5') !== -1, stderr);
+        done();
+      });
+    });
+
+    it('skips hooks for shims run by a hook', (done) => {
+      const { nvuHome, testDir } = createHookProject({ 'pre-exec': 'echo "hook ran $("$NVU_TEST_SHIM" --version)"' });
+      runNode(nvuHome, testDir, { NVU_TEST_SHIM: path.join(getTestBinaryBin(), NODE) }, (err, stdout, stderr) => {
+        if (err) return done(err);
+        assert.equal(stdout, 'v22.3.0');
+        assert.equal(stderr.split('hook ran').length - 1, 1, 'the hook should run once');
+        assert.ok(stderr.indexOf('hook ran v22.3.0') !== -1, stderr);
+        done();
+      });
+    });
+
+    it('kills a hook that runs out of time along with its children', (done) => {
+      const { nvuHome, testDir } = createHookProject({ 'pre-exec': `(sleep 2; echo late > "${path.join(TMP_DIR, 'hooks', 'late')}") &\nsleep 10` });
+      const env = { NVU_HOOK_TIMEOUT: '500ms', PATH: `${OPTIONS.env.PATH}${path.delimiter}${process.env.PATH}` };
+      runNode(nvuHome, testDir, env, (err, _stdout, stderr) => {
+        assert.ok(err, 'the command should fail');
+        assert.equal(err.status, 124, stderr);
+        setTimeout(() => {
+          assert.ok(!fs.existsSync(path.join(TMP_DIR, 'hooks', 'late')), "the hook's children should be killed");
+          done();
+        }, 2500);
+      });
+    });
+  });
+
+  describe('project configuration', () => {
+    const nvuHome = path.join(TMP_DIR, 'project');
+
+    before(function () {
+      // the fake node is a shell script that prints its environment
+      if (isWindows) return this.skip();
+      createFakeNodeVersion('v22.3.0', nvuHome);
+      writeScript(path.join(nvuHome, 'installed', 'v22.3.0', 'bin', 'node'), 'echo "A=$NVU_TEST_A B=$NVU_TEST_B PATH=${PATH%%:*}"');
+      fs.writeFileSync(path.join(nvuHome, 'default'), '22\n');
+    });
+
+    // Writes a .nvurc in a fresh project directory and runs node there with
+    // NVU_TEST_B inherited
+    function runProject(content: string, callback: (err: Error | null, stdout: string, stderr: string, testDir: string) => void): void {
+      const testDir = createProject(nvuHome, { '.nvurc': content });
+      runNode(nvuHome, testDir, { NVU_TEST_B: 'inherited' }, (err, stdout, stderr) => callback(err, stdout, stderr, testDir));
+    }
+
+    it('reads env keys in the plain form', (done) => {
+      runProject('22\nenv.NVU_TEST_A = plain\n', (err, stdout) => {
+        if (err) return done(err);
+        assert.ok(stdout.indexOf('A=plain B=inherited') === 0, stdout);
+        done();
+      });
+    });
+
+    it('reads tables, unset and pathPrepend in the TOML form', (done) => {
+      runProject('version = "22"\nunset = ["NVU_TEST_B"]\npathPrepend = ["tools"]\n\n[env]\nNVU_TEST_A = "toml" # comment\n', (err, stdout, _stderr, testDir) => {
+        if (err) return done(err);
+        assert.equal(stdout, `A=toml B= PATH=${path.join(testDir, 'tools')}`);
+        done();
+      });
+    });
+
+    it('reads the JSON form', (done) => {
+      runProject('{ "version": "22", "env": { "NVU_TEST_A": "json" }, "unset": "NVU_TEST_B" }\n', (err, stdout) => {
+        if (err) return done(err);
+        assert.ok(stdout.indexOf('A=json B=') === 0, stdout);
+        done();
+      });
+    });
+
+    it('reads quoted keys and inline tables in the TOML form', (done) => {
+      runProject("version = \"22\"\nenv = { NVU_TEST_A = \"inline\", 'NVU_TEST_B' = 'literal' }\n", (err, stdout) => {
+        if (err) return done(err);
+        assert.ok(stdout.indexOf('A=inline B=literal') === 0, stdout);
+        done();
+      });
+    });
+
+    it('continues an array past a quoted ] and a comment', (done) => {
+      runProject('version = "22"\npathPrepend = [\n  "tools]", # not the end]\n  "bin#1",\n]\n', (err, stdout, _stderr, testDir) => {
+        if (err) return done(err);
+        assert.equal(stdout, `A= B=inherited PATH=${path.join(testDir, 'tools]')}`);
+        done();
+      });
+    });
+
+    it('applies when the version comes from NVU_VERSION', (done) => {
+      const testDir = createProject(nvuHome, { '.nvurc': '22\nenv.NVU_TEST_A = project\n' });
+      runNode(nvuHome, testDir, { NVU_VERSION: '22' }, (err, stdout) => {
+        if (err) return done(err);
+        assert.ok(stdout.indexOf('A=project') === 0, stdout);
+        done();
+      });
+    });
+
+    it('applies in a subdirectory with its own .nvmrc', (done) => {
+      const testDir = createProject(nvuHome, { '.nvurc': '[env]\nNVU_TEST_A = "parent"\n', 'sub/.nvmrc': '22\n' });
+      runNode(nvuHome, path.join(testDir, 'sub'), {}, (err, stdout) => {
+        if (err) return done(err);
+        assert.ok(stdout.indexOf('A=parent') === 0, stdout);
+        done();
+      });
+    });
+
+    it('applies without a version, which then comes from the default', (done) => {
+      runProject('[env]\nNVU_TEST_A = "env-only"\n', (err, stdout) => {
+        if (err) return done(err);
+        assert.ok(stdout.indexOf('A=env-only B=inherited') === 0, stdout);
+        done();
+      });
+    });
+
+    it('reports the line of a parse error', (done) => {
+      runProject('version = "22"\nenv = { NVU_TEST_A = "open"\n', (err, _stdout, stderr) => {
+        assert.ok(err, 'an unparseable .nvurc should fail');
+        assert.ok(stderr.indexOf('.nvurc: line 2: unterminated inline table') !== -1, stderr);
+        done();
+      });
+    });
+  });
+
+  describe('loop protection', () => {
+    const nvuHome = path.join(TMP_DIR, 'loop');
+    const nodePath = path.join(nvuHome, 'installed', 'v22.3.0', 'bin', 'node');
+    let testDir: string;
+
+    before(function () {
+      // the wrappers are shell scripts
+      if (isWindows) return this.skip();
+      createFakeNodeVersion('v22.3.0', nvuHome);
+      testDir = createProject(nvuHome, { '.nvmrc': '22\n' });
+    });
+
+    it('stops a wrapper that runs the shim again without exec', (done) => {
+      writeScript(nodePath, '"$NVU_TEST_SHIM" "$@"');
+      runNode(nvuHome, testDir, { NVU_TEST_SHIM: path.join(getTestBinaryBin(), NODE) }, expectFailure('shim loop', done));
+    });
+
+    it('stops a loop whose arguments change every hop', (done) => {
+      writeScript(nodePath, '"$NVU_TEST_SHIM" $(($1 + 1))');
+      runShim(NODE, ['0'], nvuHome, testDir, { NVU_TEST_SHIM: path.join(getTestBinaryBin(), NODE) }, expectFailure('more than the 32 allowed', done));
+    });
+
+    it('allows nesting with different arguments', (done) => {
+      writeScript(nodePath, 'if [ "$1" -gt 0 ]; then "$NVU_TEST_SHIM" $(($1 - 1)); else echo bottom; fi');
+      runShim(NODE, ['10'], nvuHome, testDir, { NVU_TEST_SHIM: path.join(getTestBinaryBin(), NODE) }, expectVersion('bottom', done));
+    });
+
+    it('allows the same command nested in different directories', (done) => {
+      // runs `node build` again in ws below, as npm scripts do across workspaces
+      const workspaceDir = createProject(nvuHome, { '.nvmrc': '22\n', 'ws/ws/ws/ws/ws/ws/.keep': '' });
+      writeScript(nodePath, 'if [ -d ws ]; then cd ws && "$NVU_TEST_SHIM" build; else echo bottom; fi');
+      runShim(NODE, ['build'], nvuHome, workspaceDir, { NVU_TEST_SHIM: path.join(getTestBinaryBin(), NODE) }, (err, stdout, stderr) => {
+        if (err) return done(new Error(`${err.message}\n${stderr}`));
+        assert.equal(stdout, 'bottom');
+
+        // the limit is configurable
+        runShim(NODE, ['build'], nvuHome, workspaceDir, { NVU_TEST_SHIM: path.join(getTestBinaryBin(), NODE), NVU_MAX_SHIM_HOPS: '4' }, expectFailure('more than the 4 allowed', done));
+      });
+    });
+  });
+
   describe('system fallback', () => {
     it('falls back to system node when no config exists', (done) => {
       // Use a directory outside the project tree to avoid inheriting .nvmrc